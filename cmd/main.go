@@ -541,6 +541,7 @@ func main() {
 			return
 		}
 	}
+	defer winapi.WipeBytes(payload)
 
 	// Determine injection method: self-injection or remote injection
 	// If -self is explicitly used, force self-injection
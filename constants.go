@@ -17,9 +17,18 @@ const (
 	MEM_TOP_DOWN    = 0x100000
 	MEM_WRITE_WATCH = 0x200000
 	MEM_PHYSICAL    = 0x400000
+	MEM_IMAGE       = 0x1000000
 	MEM_LARGE_PAGES = 0x20000000
 )
 
+// Placeholder allocation flags (Windows 10 1803+)
+const (
+	MEM_COALESCE_PLACEHOLDERS = 0x00000001 // NtFreeVirtualMemory: merge adjacent placeholders
+	MEM_PRESERVE_PLACEHOLDER  = 0x00000002 // NtFreeVirtualMemory/NtUnmapViewOfSectionEx: split or keep a placeholder
+	MEM_REPLACE_PLACEHOLDER   = 0x00004000 // NtAllocateVirtualMemoryEx/NtMapViewOfSectionEx: fill a placeholder
+	MEM_RESERVE_PLACEHOLDER   = 0x00040000 // NtAllocateVirtualMemoryEx: reserve as placeholder
+)
+
 // Memory protection constants
 const (
 	PAGE_NOACCESS          = 0x01
@@ -190,6 +199,22 @@ const (
 	SystemFlagsInformation                = 9
 	SystemCallTimeInformation             = 10
 	SystemModuleInformation               = 11
+	SystemExtendedHandleInformation       = 64
+)
+
+// Object information classes for NtQueryObject
+const (
+	ObjectBasicInformation = 0
+	ObjectNameInformation  = 1
+	ObjectTypeInformation  = 2
+	ObjectTypesInformation = 3
+)
+
+// NtDuplicateObject options
+const (
+	DUPLICATE_CLOSE_SOURCE    = 0x1
+	DUPLICATE_SAME_ACCESS     = 0x2
+	DUPLICATE_SAME_ATTRIBUTES = 0x4
 )
 
 // Process information classes
@@ -245,6 +270,77 @@ const (
 	ProcessTokenVirtualizationEnabled       = 48
 	ProcessConsoleHostProcess                = 49
 	ProcessWindowInformation                 = 50
+	ProcessHandleInformation                 = 51
+	ProcessMitigationPolicy                  = 52
+	ProcessDynamicFunctionTableInformation   = 53
+	ProcessHandleCheckingMode                = 54
+	ProcessKeepAliveCount                    = 55
+	ProcessRevokeFileHandles                 = 56
+	ProcessWorkingSetControl                 = 57
+	ProcessHandleTable                       = 58
+	ProcessCheckStackExtentsMode             = 59
+	ProcessCommandLineInformation            = 60
+	ProcessProtectionInformation             = 61
+)
+
+// PROCESS_MITIGATION_POLICY values for ProcessMitigationPolicy
+const (
+	ProcessDEPPolicy                   = 0
+	ProcessASLRPolicy                  = 1
+	ProcessDynamicCodePolicy           = 2
+	ProcessStrictHandleCheckPolicy     = 3
+	ProcessSystemCallDisablePolicy     = 4
+	ProcessMitigationOptionsMask       = 5
+	ProcessExtensionPointDisablePolicy = 6
+	ProcessControlFlowGuardPolicy      = 7
+	ProcessSignaturePolicy             = 8
+	ProcessFontDisablePolicy           = 9
+	ProcessImageLoadPolicy             = 10
+	ProcessSystemCallFilterPolicy      = 11
+	ProcessPayloadRestrictionPolicy    = 12
+	ProcessChildProcessPolicy          = 13
+)
+
+// Flag bits of the individual PROCESS_MITIGATION_*_POLICY structures
+const (
+	MITIGATION_SIGNATURE_MICROSOFT_SIGNED_ONLY   = 0x1 // ProcessSignaturePolicy
+	MITIGATION_SIGNATURE_STORE_SIGNED_ONLY       = 0x2
+	MITIGATION_SIGNATURE_OPT_IN                  = 0x4
+	MITIGATION_DYNAMIC_CODE_PROHIBIT             = 0x1 // ProcessDynamicCodePolicy
+	MITIGATION_DYNAMIC_CODE_ALLOW_THREAD_OPT_OUT = 0x2
+	MITIGATION_IMAGE_LOAD_NO_REMOTE              = 0x1 // ProcessImageLoadPolicy
+	MITIGATION_IMAGE_LOAD_NO_LOW_LABEL           = 0x2
+	MITIGATION_IMAGE_LOAD_PREFER_SYSTEM32        = 0x4
+	MITIGATION_EXTENSION_POINT_DISABLE           = 0x1 // ProcessExtensionPointDisablePolicy
+	MITIGATION_STRICT_HANDLE_RAISE_ON_INVALID    = 0x1 // ProcessStrictHandleCheckPolicy
+	MITIGATION_STRICT_HANDLE_PERMANENT           = 0x2
+	MITIGATION_CHILD_PROCESS_NO_CREATION         = 0x1 // ProcessChildProcessPolicy
+	MITIGATION_FONT_DISABLE_NON_SYSTEM           = 0x1 // ProcessFontDisablePolicy
+)
+
+// PROCESS_MITIGATION_POLICY_INFORMATION is passed to Nt{Set,Query}InformationProcess with
+// ProcessMitigationPolicy; Flags is the policy-specific bitfield
+type PROCESS_MITIGATION_POLICY_INFORMATION struct {
+	Policy uint32
+	Flags  uint32
+}
+
+// PS_PROTECTION fields returned by ProcessProtectionInformation: the type is in bits 0-2 and
+// the signer in bits 4-7 of the single byte
+const (
+	PS_PROTECTED_TYPE_NONE            = 0
+	PS_PROTECTED_TYPE_PROTECTED_LIGHT = 1
+	PS_PROTECTED_TYPE_PROTECTED       = 2
+
+	PS_PROTECTED_SIGNER_NONE         = 0
+	PS_PROTECTED_SIGNER_AUTHENTICODE = 1
+	PS_PROTECTED_SIGNER_CODEGEN      = 2
+	PS_PROTECTED_SIGNER_ANTIMALWARE  = 3
+	PS_PROTECTED_SIGNER_LSA          = 4
+	PS_PROTECTED_SIGNER_WINDOWS      = 5
+	PS_PROTECTED_SIGNER_WINTCB       = 6
+	PS_PROTECTED_SIGNER_WINSYSTEM    = 7
+	PS_PROTECTED_SIGNER_APP          = 8
 )
 
 // NTSTATUS codes
@@ -256,6 +352,7 @@ const (
 	STATUS_INVALID_HANDLE         = 0xC0000008
 	STATUS_INVALID_PARAMETER      = 0xC000000D
 	STATUS_NO_MEMORY              = 0xC0000017
+	STATUS_CONFLICTING_ADDRESSES  = 0xC0000018
 	STATUS_ACCESS_DENIED          = 0xC0000022
 	STATUS_BUFFER_TOO_SMALL       = 0xC0000023
 	STATUS_OBJECT_TYPE_MISMATCH   = 0xC0000024
@@ -269,6 +366,12 @@ const (
 	STATUS_INVALID_VIEW_SIZE      = 0xC000001F
 	STATUS_ALREADY_COMMITTED      = 0xC0000021
 	STATUS_TIMEOUT                = 0xC0000102
+	STATUS_PENDING                = 0x00000103 // also the exit status of a thread that is still running
+	STATUS_NOT_ALL_ASSIGNED       = 0x00000106
+	STATUS_CANCELLED              = 0xC0000120
+	STATUS_PIPE_DISCONNECTED      = 0xC00000B0
+	STATUS_PIPE_CONNECTED         = 0xC00000B2
+	STATUS_PIPE_BROKEN            = 0xC000014B
 )
 
 // Wait constants
@@ -296,6 +399,70 @@ const (
 	THREAD_CREATE_FLAGS_INITIAL_THREAD = 0x00000080
 )
 
+// PS_ATTRIBUTE identifiers for NtCreateThreadEx / NtCreateUserProcess attribute lists
+// (PsAttributeValue(number, thread, input, additive))
+const (
+	PS_ATTRIBUTE_TOKEN              = 0x00060002 // input: primary token handle (process creation)
+	PS_ATTRIBUTE_CLIENT_ID          = 0x00010003 // thread, output: CLIENT_ID
+	PS_ATTRIBUTE_TEB_ADDRESS        = 0x00010004 // thread, output: TEB address
+	PS_ATTRIBUTE_IMAGE_NAME         = 0x00020005 // input: NT path of the image (process creation)
+	PS_ATTRIBUTE_IMAGE_INFO         = 0x00000006 // output: SECTION_IMAGE_INFORMATION
+	PS_ATTRIBUTE_STD_HANDLE_INFO    = 0x0002000A // input: PS_STD_HANDLE_INFO
+	PS_ATTRIBUTE_MITIGATION_OPTIONS = 0x00060010 // input: process mitigation policy bits
+)
+
+// PS_ATTRIBUTE is one entry of a PS_ATTRIBUTE_LIST
+type PS_ATTRIBUTE struct {
+	Attribute    uintptr
+	Size         uintptr
+	Value        uintptr // value or pointer, depending on the attribute
+	ReturnLength *uintptr
+}
+
+// PS_CREATE_INFO is passed to NtCreateUserProcess; only the initial state is filled in by the
+// caller, the kernel writes the union on return
+type PS_CREATE_INFO struct {
+	Size                 uintptr
+	State                uint32 // PsCreateInitialState on input
+	_                    uint32
+	InitFlags            uint32
+	AdditionalFileAccess uint32
+	_                    [0x40]byte
+}
+
+// NtCreateUserProcess process flags
+const (
+	PROCESS_CREATE_FLAGS_BREAKAWAY        = 0x00000001
+	PROCESS_CREATE_FLAGS_NO_DEBUG_INHERIT = 0x00000002
+	PROCESS_CREATE_FLAGS_INHERIT_HANDLES  = 0x00000004
+	PROCESS_CREATE_FLAGS_SUSPENDED        = 0x00000200
+)
+
+// RtlCreateProcessParametersEx flags
+const (
+	RTL_USER_PROC_PARAMS_NORMALIZED = 0x00000001
+)
+
+// PROCESS_CREATION_MITIGATION_POLICY_* bits for PS_ATTRIBUTE_MITIGATION_OPTIONS
+const (
+	PROCESS_CREATION_MITIGATION_POLICY_DEP_ENABLE                             = uint64(0x01)
+	PROCESS_CREATION_MITIGATION_POLICY_SEHOP_ENABLE                           = uint64(0x04)
+	PROCESS_CREATION_MITIGATION_POLICY_FORCE_RELOCATE_IMAGES_ALWAYS_ON        = uint64(0x1) << 8
+	PROCESS_CREATION_MITIGATION_POLICY_HEAP_TERMINATE_ALWAYS_ON               = uint64(0x1) << 12
+	PROCESS_CREATION_MITIGATION_POLICY_BOTTOM_UP_ASLR_ALWAYS_ON               = uint64(0x1) << 16
+	PROCESS_CREATION_MITIGATION_POLICY_HIGH_ENTROPY_ASLR_ALWAYS_ON            = uint64(0x1) << 20
+	PROCESS_CREATION_MITIGATION_POLICY_STRICT_HANDLE_CHECKS_ALWAYS_ON         = uint64(0x1) << 24
+	PROCESS_CREATION_MITIGATION_POLICY_WIN32K_SYSTEM_CALL_DISABLE_ALWAYS_ON   = uint64(0x1) << 28
+	PROCESS_CREATION_MITIGATION_POLICY_EXTENSION_POINT_DISABLE_ALWAYS_ON      = uint64(0x1) << 32
+	PROCESS_CREATION_MITIGATION_POLICY_PROHIBIT_DYNAMIC_CODE_ALWAYS_ON        = uint64(0x1) << 36
+	PROCESS_CREATION_MITIGATION_POLICY_CONTROL_FLOW_GUARD_ALWAYS_ON           = uint64(0x1) << 40
+	PROCESS_CREATION_MITIGATION_POLICY_BLOCK_NON_MICROSOFT_BINARIES_ALWAYS_ON = uint64(0x1) << 44
+	PROCESS_CREATION_MITIGATION_POLICY_FONT_DISABLE_ALWAYS_ON                 = uint64(0x1) << 48
+	PROCESS_CREATION_MITIGATION_POLICY_IMAGE_LOAD_NO_REMOTE_ALWAYS_ON         = uint64(0x1) << 52
+	PROCESS_CREATION_MITIGATION_POLICY_IMAGE_LOAD_NO_LOW_LABEL_ALWAYS_ON      = uint64(0x1) << 56
+	PROCESS_CREATION_MITIGATION_POLICY_IMAGE_LOAD_PREFER_SYSTEM32_ALWAYS_ON   = uint64(0x1) << 60
+)
+
 // Windows API Structures
 // These structures are used for direct syscalls and process enumeration
 
@@ -367,6 +534,95 @@ type SYSTEM_PROCESS_INFORMATION struct {
 	OtherTransferCount           int64
 }
 
+// SYSTEM_THREAD_INFORMATION entries follow each SYSTEM_PROCESS_INFORMATION entry
+// (NumberOfThreads of them) in the SystemProcessInformation buffer
+type SYSTEM_THREAD_INFORMATION struct {
+	KernelTime      int64
+	UserTime        int64
+	CreateTime      int64
+	WaitTime        uint32
+	StartAddress    uintptr
+	ClientId        CLIENT_ID
+	Priority        int32
+	BasePriority    int32
+	ContextSwitches uint32
+	ThreadState     uint32
+	WaitReason      uint32
+}
+
+// SYSTEM_HANDLE_TABLE_ENTRY_INFO_EX entries follow a two-pointer header (NumberOfHandles,
+// Reserved) in the SystemExtendedHandleInformation buffer
+type SYSTEM_HANDLE_TABLE_ENTRY_INFO_EX struct {
+	Object                uintptr
+	UniqueProcessId       uintptr
+	HandleValue           uintptr
+	GrantedAccess         uint32
+	CreatorBackTraceIndex uint16
+	ObjectTypeIndex       uint16
+	HandleAttributes      uint32
+	Reserved              uint32
+}
+
+// Thread states (KTHREAD_STATE) and selected wait reasons (KWAIT_REASON)
+const (
+	ThreadStateRunning = 2
+	ThreadStateWaiting = 5
+
+	WaitReasonExecutive         = 0
+	WaitReasonDelayExecution    = 4
+	WaitReasonSuspended         = 5
+	WaitReasonUserRequest       = 6
+	WaitReasonWrUserRequest     = 13
+	WaitReasonWrQueue           = 15
+	WaitReasonWrAlertByThreadId = 37
+)
+
+// CONTEXT flags (x64)
+const (
+	CONTEXT_AMD64           = 0x00100000
+	CONTEXT_CONTROL         = CONTEXT_AMD64 | 0x01
+	CONTEXT_INTEGER         = CONTEXT_AMD64 | 0x02
+	CONTEXT_SEGMENTS        = CONTEXT_AMD64 | 0x04
+	CONTEXT_FLOATING_POINT  = CONTEXT_AMD64 | 0x08
+	CONTEXT_DEBUG_REGISTERS = CONTEXT_AMD64 | 0x10
+	CONTEXT_FULL            = CONTEXT_CONTROL | CONTEXT_INTEGER | CONTEXT_FLOATING_POINT
+	CONTEXT_ALL             = CONTEXT_FULL | CONTEXT_SEGMENTS | CONTEXT_DEBUG_REGISTERS
+)
+
+// CONTEXT is the x64 thread context used by NtGetContextThread / NtSetContextThread.
+// The kernel requires it to be 16-byte aligned; use NewContext rather than a plain variable.
+type CONTEXT struct {
+	P1Home, P2Home, P3Home, P4Home, P5Home, P6Home uint64
+
+	ContextFlags uint32
+	MxCsr        uint32
+
+	SegCs, SegDs, SegEs, SegFs, SegGs, SegSs uint16
+	EFlags                                   uint32
+
+	Dr0, Dr1, Dr2, Dr3, Dr6, Dr7 uint64
+
+	Rax, Rcx, Rdx, Rbx, Rsp, Rbp, Rsi, Rdi uint64
+	R8, R9, R10, R11, R12, R13, R14, R15   uint64
+	Rip                                    uint64
+
+	FltSave        [512]byte // XMM_SAVE_AREA32
+	VectorRegister [26][16]byte
+	VectorControl  uint64
+
+	DebugControl         uint64
+	LastBranchToRip      uint64
+	LastBranchFromRip    uint64
+	LastExceptionToRip   uint64
+	LastExceptionFromRip uint64
+}
+
+// NtQueueApcThreadEx flags (passed in place of the reserve handle)
+const (
+	QUEUE_USER_APC_FLAGS_NONE             = 0
+	QUEUE_USER_APC_FLAGS_SPECIAL_USER_APC = 1 // Windows 10 1903+: delivered without an alertable wait
+)
+
 // PROCESS_BASIC_INFORMATION structure for NtQueryInformationProcess
 type PROCESS_BASIC_INFORMATION struct {
 	ExitStatus                   uintptr
@@ -377,6 +633,33 @@ type PROCESS_BASIC_INFORMATION struct {
 	InheritedFromUniqueProcessId uintptr
 }
 
+// THREAD_BASIC_INFORMATION structure for NtQueryInformationThread(ThreadBasicInformation)
+type THREAD_BASIC_INFORMATION struct {
+	ExitStatus     uint32
+	TebBaseAddress uintptr
+	ClientId       CLIENT_ID
+	AffinityMask   uintptr
+	Priority       int32
+	BasePriority   int32
+}
+
+// Memory information classes for NtQueryVirtualMemory
+const (
+	MemoryBasicInformation = 0
+)
+
+// MEMORY_BASIC_INFORMATION structure for NtQueryVirtualMemory
+type MEMORY_BASIC_INFORMATION struct {
+	BaseAddress       uintptr
+	AllocationBase    uintptr
+	AllocationProtect uint32
+	PartitionId       uint16
+	RegionSize        uintptr
+	State             uint32
+	Protect           uint32
+	Type              uint32
+}
+
 // Token access rights
 const (
 	TOKEN_ASSIGN_PRIMARY    = 0x0001
@@ -442,6 +725,19 @@ const (
 	ViewUnmap  = 2
 )
 
+// Event types for NtCreateEvent
+const (
+	NotificationEvent    = 0
+	SynchronizationEvent = 1
+)
+
+// Event access rights
+const (
+	EVENT_QUERY_STATE  = 0x0001
+	EVENT_MODIFY_STATE = 0x0002
+	EVENT_ALL_ACCESS   = STANDARD_RIGHTS_REQUIRED | SYNCHRONIZE | 0x3
+)
+
 // Object attributes flags
 const (
 	OBJ_INHERIT                    = 0x00000002
@@ -580,6 +876,58 @@ const (
 	TokenSandBoxInert
 	TokenAuditPolicy
 	TokenOrigin
+	TokenElevationType
+	TokenLinkedToken
+	TokenElevation
+	TokenHasRestrictions
+	TokenAccessInformation
+	TokenVirtualizationAllowed
+	TokenVirtualizationEnabled
+	TokenIntegrityLevel
+	TokenUIAccess
+	TokenMandatoryPolicy
+	TokenLogonSid
+	TokenIsAppContainer
+	TokenCapabilities
+	TokenAppContainerSid
+	TokenAppContainerNumber
+	TokenUserClaimAttributes
+	TokenDeviceClaimAttributes
+	TokenRestrictedUserClaimAttributes
+	TokenRestrictedDeviceClaimAttributes
+	TokenDeviceGroups
+	TokenRestrictedDeviceGroups
+	TokenSecurityAttributes
+	TokenIsRestricted
+)
+
+// Mandatory integrity level RIDs (last sub-authority of the S-1-16-x label SID)
+const (
+	SECURITY_MANDATORY_UNTRUSTED_RID         = 0x0000
+	SECURITY_MANDATORY_LOW_RID               = 0x1000
+	SECURITY_MANDATORY_MEDIUM_RID            = 0x2000
+	SECURITY_MANDATORY_MEDIUM_PLUS_RID       = 0x2100
+	SECURITY_MANDATORY_HIGH_RID              = 0x3000
+	SECURITY_MANDATORY_SYSTEM_RID            = 0x4000
+	SECURITY_MANDATORY_PROTECTED_PROCESS_RID = 0x5000
+)
+
+// SID_AND_ATTRIBUTES structure (TOKEN_USER, TOKEN_MANDATORY_LABEL, TOKEN_GROUPS entries)
+type SID_AND_ATTRIBUTES struct {
+	Sid        uintptr
+	Attributes uint32
+}
+
+// Group attributes (SID_AND_ATTRIBUTES.Attributes)
+const (
+	SE_GROUP_MANDATORY          = 0x00000001
+	SE_GROUP_ENABLED_BY_DEFAULT = 0x00000002
+	SE_GROUP_ENABLED            = 0x00000004
+	SE_GROUP_OWNER              = 0x00000008
+	SE_GROUP_USE_FOR_DENY_ONLY  = 0x00000010
+	SE_GROUP_INTEGRITY          = 0x00000020
+	SE_GROUP_INTEGRITY_ENABLED  = 0x00000040
+	SE_GROUP_LOGON_ID           = 0xC0000000
 )
 
 // Privilege constants (LUID values)
@@ -607,8 +955,115 @@ const (
 	SE_SYSTEM_ENVIRONMENT_PRIVILEGE      = 22
 	SE_CHANGE_NOTIFY_PRIVILEGE           = 23
 	SE_REMOTE_SHUTDOWN_PRIVILEGE         = 24
+	SE_UNDOCK_PRIVILEGE                  = 25
+	SE_SYNC_AGENT_PRIVILEGE              = 26
+	SE_ENABLE_DELEGATION_PRIVILEGE       = 27
+	SE_MANAGE_VOLUME_PRIVILEGE           = 28
+	SE_IMPERSONATE_PRIVILEGE             = 29
+	SE_CREATE_GLOBAL_PRIVILEGE           = 30
+	SE_TRUSTED_CREDMAN_ACCESS_PRIVILEGE  = 31
+	SE_RELABEL_PRIVILEGE                 = 32
+	SE_INC_WORKING_SET_PRIVILEGE         = 33
+	SE_TIME_ZONE_PRIVILEGE               = 34
+	SE_CREATE_SYMBOLIC_LINK_PRIVILEGE    = 35
 )
 
+// Privilege attributes (LUID_AND_ATTRIBUTES.Attributes)
+const (
+	SE_PRIVILEGE_ENABLED_BY_DEFAULT = 0x00000001
+	SE_PRIVILEGE_ENABLED            = 0x00000002
+	SE_PRIVILEGE_REMOVED            = 0x00000004
+	SE_PRIVILEGE_USED_FOR_ACCESS    = 0x80000000
+)
+
+// Named pipe constants (NtCreateNamedPipeFile, NtFsControlFile)
+const (
+	FILE_PIPE_BYTE_STREAM_TYPE    = 0
+	FILE_PIPE_MESSAGE_TYPE        = 1
+	FILE_PIPE_BYTE_STREAM_MODE    = 0
+	FILE_PIPE_MESSAGE_MODE        = 1
+	FILE_PIPE_QUEUE_OPERATION     = 0
+	FILE_PIPE_UNLIMITED_INSTANCES = 0xFFFFFFFF
+
+	FSCTL_PIPE_DISCONNECT  = 0x00110004
+	FSCTL_PIPE_LISTEN      = 0x00110008
+	FSCTL_PIPE_IMPERSONATE = 0x0011001C
+)
+
+// Security descriptor and ACL constants
+const (
+	SECURITY_DESCRIPTOR_REVISION   = 1
+	SECURITY_DESCRIPTOR_MIN_LENGTH = 40 // absolute SECURITY_DESCRIPTOR on x64
+	ACL_REVISION                   = 2
+)
+
+// ACE inheritance flags (Ace.Flags)
+const (
+	OBJECT_INHERIT_ACE       = 0x01
+	CONTAINER_INHERIT_ACE    = 0x02
+	NO_PROPAGATE_INHERIT_ACE = 0x04
+	INHERIT_ONLY_ACE         = 0x08
+)
+
+// TOKEN_ELEVATION_TYPE values (TokenElevationType)
+const (
+	TokenElevationTypeDefault = 1 // UAC disabled or not a split token
+	TokenElevationTypeFull    = 2 // elevated half of a split token
+	TokenElevationTypeLimited = 3 // filtered half of a split token
+)
+
+// NtFilterToken flags
+const (
+	DISABLE_MAX_PRIVILEGE = 0x1
+	SANDBOX_INERT         = 0x2
+	LUA_TOKEN             = 0x4
+	WRITE_RESTRICTED      = 0x8
+)
+
+// Capability RIDs (S-1-15-3-<rid>) for lowbox tokens
+const (
+	SECURITY_CAPABILITY_INTERNET_CLIENT               = 1
+	SECURITY_CAPABILITY_INTERNET_CLIENT_SERVER        = 2
+	SECURITY_CAPABILITY_PRIVATE_NETWORK_CLIENT_SERVER = 3
+	SECURITY_CAPABILITY_PICTURES_LIBRARY              = 4
+	SECURITY_CAPABILITY_VIDEOS_LIBRARY                = 5
+	SECURITY_CAPABILITY_MUSIC_LIBRARY                 = 6
+	SECURITY_CAPABILITY_DOCUMENTS_LIBRARY             = 7
+)
+
+// LogonUserW logon types and providers
+const (
+	LOGON32_LOGON_INTERACTIVE       = 2
+	LOGON32_LOGON_NETWORK           = 3
+	LOGON32_LOGON_BATCH             = 4
+	LOGON32_LOGON_SERVICE           = 5
+	LOGON32_LOGON_NETWORK_CLEARTEXT = 8
+	LOGON32_LOGON_NEW_CREDENTIALS   = 9
+	LOGON32_PROVIDER_DEFAULT        = 0
+)
+
+// TOKEN_TYPE values (NtDuplicateToken)
+const (
+	TokenPrimary       = 1
+	TokenImpersonation = 2
+)
+
+// SECURITY_IMPERSONATION_LEVEL values
+const (
+	SecurityAnonymous      = 0
+	SecurityIdentification = 1
+	SecurityImpersonation  = 2
+	SecurityDelegation     = 3
+)
+
+// SECURITY_QUALITY_OF_SERVICE structure (OBJECT_ATTRIBUTES.SecurityQualityOfService)
+type SECURITY_QUALITY_OF_SERVICE struct {
+	Length              uint32
+	ImpersonationLevel  uint32
+	ContextTrackingMode byte
+	EffectiveOnly       byte
+}
+
 // Registry constants
 const (
 	KEY_ALL_ACCESS          = 0xF003F
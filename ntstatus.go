@@ -14,6 +14,7 @@ func FormatNTStatus(status uintptr) string {
 		STATUS_INVALID_HANDLE:              "STATUS_INVALID_HANDLE",
 		STATUS_INVALID_PARAMETER:           "STATUS_INVALID_PARAMETER",
 		STATUS_NO_MEMORY:                   "STATUS_NO_MEMORY",
+		STATUS_CONFLICTING_ADDRESSES:       "STATUS_CONFLICTING_ADDRESSES",
 		STATUS_ACCESS_DENIED:               "STATUS_ACCESS_DENIED",
 		STATUS_BUFFER_TOO_SMALL:            "STATUS_BUFFER_TOO_SMALL",
 		STATUS_OBJECT_TYPE_MISMATCH:        "STATUS_OBJECT_TYPE_MISMATCH",
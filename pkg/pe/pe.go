@@ -0,0 +1,459 @@
+// Package pe provides bounds-checked, typed parsing of PE images, either as raw files or as
+// images mapped in memory by the loader.
+package pe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Header signatures and optional header magics
+const (
+	DosSignature = 0x5A4D     // MZ
+	NtSignature  = 0x00004550 // PE\0\0
+
+	OptionalHeader32Magic = 0x10B
+	OptionalHeader64Magic = 0x20B
+)
+
+// Data directory indexes
+const (
+	DirectoryExport        = 0
+	DirectoryImport        = 1
+	DirectoryResource      = 2
+	DirectoryException     = 3
+	DirectorySecurity      = 4
+	DirectoryBaseReloc     = 5
+	DirectoryDebug         = 6
+	DirectoryArchitecture  = 7
+	DirectoryGlobalPtr     = 8
+	DirectoryTLS           = 9
+	DirectoryLoadConfig    = 10
+	DirectoryBoundImport   = 11
+	DirectoryIAT           = 12
+	DirectoryDelayImport   = 13
+	DirectoryCOMDescriptor = 14
+
+	numberOfDirectories = 16
+)
+
+// Section characteristics
+const (
+	ScnCntCode            = 0x00000020
+	ScnCntInitializedData = 0x00000040
+	ScnMemDiscardable     = 0x02000000
+	ScnMemShared          = 0x10000000
+	ScnMemExecute         = 0x20000000
+	ScnMemRead            = 0x40000000
+	ScnMemWrite           = 0x80000000
+)
+
+// Limits that keep a malformed image from driving the parser into huge allocations or loops
+const (
+	maxSections          = 96
+	maxImportDescriptors = 4096
+	maxImportThunks      = 65536
+	maxExports           = 65536
+	maxNameLength        = 512
+	mappedHeaderProbe    = 0x1000
+)
+
+// ErrFormat is wrapped by every error caused by a malformed or truncated image
+var ErrFormat = errors.New("malformed PE image")
+
+func formatError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrFormat, fmt.Sprintf(format, args...))
+}
+
+// DosHeader holds the fields of IMAGE_DOS_HEADER the parser uses
+type DosHeader struct {
+	Magic  uint16
+	Lfanew uint32
+}
+
+// FileHeader is IMAGE_FILE_HEADER
+type FileHeader struct {
+	Machine              uint16
+	NumberOfSections     uint16
+	TimeDateStamp        uint32
+	PointerToSymbolTable uint32
+	NumberOfSymbols      uint32
+	SizeOfOptionalHeader uint16
+	Characteristics      uint16
+}
+
+// DataDirectory is IMAGE_DATA_DIRECTORY
+type DataDirectory struct {
+	VirtualAddress uint32
+	Size           uint32
+}
+
+// OptionalHeader is IMAGE_OPTIONAL_HEADER32 or IMAGE_OPTIONAL_HEADER64 with the
+// pointer-sized fields widened to 64 bits
+type OptionalHeader struct {
+	Magic               uint16
+	AddressOfEntryPoint uint32
+	BaseOfCode          uint32
+	ImageBase           uint64
+	SectionAlignment    uint32
+	FileAlignment       uint32
+	SizeOfImage         uint32
+	SizeOfHeaders       uint32
+	CheckSum            uint32
+	Subsystem           uint16
+	DllCharacteristics  uint16
+	SizeOfStackReserve  uint64
+	SizeOfStackCommit   uint64
+	SizeOfHeapReserve   uint64
+	SizeOfHeapCommit    uint64
+	NumberOfRvaAndSizes uint32
+	DataDirectories     [numberOfDirectories]DataDirectory
+}
+
+// Section is one IMAGE_SECTION_HEADER
+type Section struct {
+	Name             string
+	VirtualSize      uint32
+	VirtualAddress   uint32
+	SizeOfRawData    uint32
+	PointerToRawData uint32
+	Characteristics  uint32
+}
+
+// Executable reports whether the section is mapped executable
+func (s *Section) Executable() bool {
+	return s.Characteristics&ScnMemExecute != 0
+}
+
+// Size returns the size of the section once mapped
+func (s *Section) Size() uint32 {
+	if s.VirtualSize != 0 {
+		return s.VirtualSize
+	}
+	return s.SizeOfRawData
+}
+
+// Contains reports whether rva falls inside the mapped section
+func (s *Section) Contains(rva uint32) bool {
+	return rva >= s.VirtualAddress && rva-s.VirtualAddress < s.Size()
+}
+
+// Image is a parsed PE image
+type Image struct {
+	data   []byte
+	mapped bool
+	base   uintptr
+
+	Dos      DosHeader
+	File     FileHeader
+	Optional OptionalHeader
+	Sections []Section
+}
+
+// Parse parses a raw PE file. data is referenced, not copied.
+func Parse(data []byte) (*Image, error) {
+	img := &Image{data: data}
+	if err := img.parseHeaders(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// FromMemory parses the image mapped at base, such as a module's DllBase. The image must stay
+// mapped while the returned Image is used.
+func FromMemory(base uintptr) (*Image, error) {
+	if base == 0 {
+		return nil, fmt.Errorf("image base is 0")
+	}
+
+	// Read the headers first to learn SizeOfImage, then cover the whole mapping
+	probe := &Image{data: unsafe.Slice((*byte)(unsafe.Pointer(base)), mappedHeaderProbe), mapped: true, base: base}
+	if err := probe.parseHeaders(); err != nil {
+		return nil, err
+	}
+	size := probe.Optional.SizeOfImage
+	if size < mappedHeaderProbe {
+		size = mappedHeaderProbe
+	}
+
+	img := &Image{data: unsafe.Slice((*byte)(unsafe.Pointer(base)), size), mapped: true, base: base}
+	if err := img.parseHeaders(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Mapped reports whether the image was parsed from memory
+func (img *Image) Mapped() bool {
+	return img.mapped
+}
+
+// Base returns the address the image is mapped at, or 0 for raw files
+func (img *Image) Base() uintptr {
+	return img.base
+}
+
+// Is64 reports whether the image is PE32+
+func (img *Image) Is64() bool {
+	return img.Optional.Magic == OptionalHeader64Magic
+}
+
+// Bytes returns the underlying file or mapping
+func (img *Image) Bytes() []byte {
+	return img.data
+}
+
+func (img *Image) parseHeaders() error {
+	magic, err := img.u16(0)
+	if err != nil || magic != DosSignature {
+		return formatError("missing MZ signature")
+	}
+	img.Dos.Magic = magic
+	if img.Dos.Lfanew, err = img.u32(0x3C); err != nil {
+		return err
+	}
+
+	nt := img.Dos.Lfanew
+	signature, err := img.u32(nt)
+	if err != nil || signature != NtSignature {
+		return formatError("missing PE signature at 0x%X", nt)
+	}
+
+	fileHeader, err := img.slice(nt+4, 20)
+	if err != nil {
+		return err
+	}
+	img.File = FileHeader{
+		Machine:              binary.LittleEndian.Uint16(fileHeader[0:]),
+		NumberOfSections:     binary.LittleEndian.Uint16(fileHeader[2:]),
+		TimeDateStamp:        binary.LittleEndian.Uint32(fileHeader[4:]),
+		PointerToSymbolTable: binary.LittleEndian.Uint32(fileHeader[8:]),
+		NumberOfSymbols:      binary.LittleEndian.Uint32(fileHeader[12:]),
+		SizeOfOptionalHeader: binary.LittleEndian.Uint16(fileHeader[16:]),
+		Characteristics:      binary.LittleEndian.Uint16(fileHeader[18:]),
+	}
+
+	optionalOffset := nt + 24
+	if err := img.parseOptionalHeader(optionalOffset); err != nil {
+		return err
+	}
+	return img.parseSections(optionalOffset + uint32(img.File.SizeOfOptionalHeader))
+}
+
+func (img *Image) parseOptionalHeader(offset uint32) error {
+	header, err := img.slice(offset, uint32(img.File.SizeOfOptionalHeader))
+	if err != nil {
+		return err
+	}
+	if len(header) < 2 {
+		return formatError("optional header too small")
+	}
+
+	o := &img.Optional
+	o.Magic = binary.LittleEndian.Uint16(header)
+	var directories uint32
+	switch o.Magic {
+	case OptionalHeader64Magic:
+		if len(header) < 0x70 {
+			return formatError("PE32+ optional header too small (%d bytes)", len(header))
+		}
+		o.ImageBase = binary.LittleEndian.Uint64(header[0x18:])
+		o.SizeOfStackReserve = binary.LittleEndian.Uint64(header[0x48:])
+		o.SizeOfStackCommit = binary.LittleEndian.Uint64(header[0x50:])
+		o.SizeOfHeapReserve = binary.LittleEndian.Uint64(header[0x58:])
+		o.SizeOfHeapCommit = binary.LittleEndian.Uint64(header[0x60:])
+		o.NumberOfRvaAndSizes = binary.LittleEndian.Uint32(header[0x6C:])
+		directories = 0x70
+	case OptionalHeader32Magic:
+		if len(header) < 0x60 {
+			return formatError("PE32 optional header too small (%d bytes)", len(header))
+		}
+		o.ImageBase = uint64(binary.LittleEndian.Uint32(header[0x1C:]))
+		o.SizeOfStackReserve = uint64(binary.LittleEndian.Uint32(header[0x48:]))
+		o.SizeOfStackCommit = uint64(binary.LittleEndian.Uint32(header[0x4C:]))
+		o.SizeOfHeapReserve = uint64(binary.LittleEndian.Uint32(header[0x50:]))
+		o.SizeOfHeapCommit = uint64(binary.LittleEndian.Uint32(header[0x54:]))
+		o.NumberOfRvaAndSizes = binary.LittleEndian.Uint32(header[0x5C:])
+		directories = 0x60
+	default:
+		return formatError("unknown optional header magic 0x%X", o.Magic)
+	}
+
+	// Fields at the same offset in both layouts
+	o.AddressOfEntryPoint = binary.LittleEndian.Uint32(header[0x10:])
+	o.BaseOfCode = binary.LittleEndian.Uint32(header[0x14:])
+	o.SectionAlignment = binary.LittleEndian.Uint32(header[0x20:])
+	o.FileAlignment = binary.LittleEndian.Uint32(header[0x24:])
+	o.SizeOfImage = binary.LittleEndian.Uint32(header[0x38:])
+	o.SizeOfHeaders = binary.LittleEndian.Uint32(header[0x3C:])
+	o.CheckSum = binary.LittleEndian.Uint32(header[0x40:])
+	o.Subsystem = binary.LittleEndian.Uint16(header[0x44:])
+	o.DllCharacteristics = binary.LittleEndian.Uint16(header[0x46:])
+
+	count := o.NumberOfRvaAndSizes
+	if count > numberOfDirectories {
+		count = numberOfDirectories
+	}
+	for i := uint32(0); i < count; i++ {
+		entry := directories + i*8
+		if int(entry)+8 > len(header) {
+			break
+		}
+		o.DataDirectories[i] = DataDirectory{
+			VirtualAddress: binary.LittleEndian.Uint32(header[entry:]),
+			Size:           binary.LittleEndian.Uint32(header[entry+4:]),
+		}
+	}
+	return nil
+}
+
+func (img *Image) parseSections(offset uint32) error {
+	count := int(img.File.NumberOfSections)
+	if count > maxSections {
+		return formatError("%d sections exceeds the limit of %d", count, maxSections)
+	}
+	table, err := img.slice(offset, uint32(count)*40)
+	if err != nil {
+		return err
+	}
+
+	img.Sections = make([]Section, count)
+	for i := range img.Sections {
+		header := table[i*40 : (i+1)*40]
+		img.Sections[i] = Section{
+			Name:             strings.TrimRight(string(header[:8]), "\x00"),
+			VirtualSize:      binary.LittleEndian.Uint32(header[8:]),
+			VirtualAddress:   binary.LittleEndian.Uint32(header[12:]),
+			SizeOfRawData:    binary.LittleEndian.Uint32(header[16:]),
+			PointerToRawData: binary.LittleEndian.Uint32(header[20:]),
+			Characteristics:  binary.LittleEndian.Uint32(header[36:]),
+		}
+	}
+	return nil
+}
+
+// Directory returns data directory index, or a zero entry when it is absent
+func (img *Image) Directory(index int) DataDirectory {
+	if index < 0 || index >= numberOfDirectories {
+		return DataDirectory{}
+	}
+	return img.Optional.DataDirectories[index]
+}
+
+// SectionByName returns the first section called name, or nil
+func (img *Image) SectionByName(name string) *Section {
+	for i := range img.Sections {
+		if img.Sections[i].Name == name {
+			return &img.Sections[i]
+		}
+	}
+	return nil
+}
+
+// SectionForRVA returns the section containing rva, or nil
+func (img *Image) SectionForRVA(rva uint32) *Section {
+	for i := range img.Sections {
+		if img.Sections[i].Contains(rva) {
+			return &img.Sections[i]
+		}
+	}
+	return nil
+}
+
+// RVAToOffset translates rva to an offset into Bytes(). For mapped images the two are equal.
+func (img *Image) RVAToOffset(rva uint32) (uint32, error) {
+	if img.mapped || rva < img.Optional.SizeOfHeaders {
+		return rva, nil
+	}
+	section := img.SectionForRVA(rva)
+	if section == nil {
+		return 0, formatError("RVA 0x%X is outside every section", rva)
+	}
+	delta := rva - section.VirtualAddress
+	if delta >= section.SizeOfRawData {
+		return 0, formatError("RVA 0x%X has no file data in %s", rva, section.Name)
+	}
+	return section.PointerToRawData + delta, nil
+}
+
+// ReadRVA returns size bytes starting at rva
+func (img *Image) ReadRVA(rva uint32, size uint32) ([]byte, error) {
+	offset, err := img.RVAToOffset(rva)
+	if err != nil {
+		return nil, err
+	}
+	return img.slice(offset, size)
+}
+
+// SectionData returns the contents of section: the mapped bytes for images in memory and the
+// raw data for files
+func (img *Image) SectionData(section *Section) ([]byte, error) {
+	if img.mapped {
+		return img.slice(section.VirtualAddress, section.Size())
+	}
+	return img.slice(section.PointerToRawData, section.SizeOfRawData)
+}
+
+func (img *Image) slice(offset uint32, size uint32) ([]byte, error) {
+	end := uint64(offset) + uint64(size)
+	if end > uint64(len(img.data)) {
+		return nil, formatError("read of %d bytes at 0x%X is out of bounds", size, offset)
+	}
+	return img.data[offset:end], nil
+}
+
+func (img *Image) u16(offset uint32) (uint16, error) {
+	b, err := img.slice(offset, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (img *Image) u32(offset uint32) (uint32, error) {
+	b, err := img.slice(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (img *Image) rvaU32(rva uint32) (uint32, error) {
+	b, err := img.ReadRVA(rva, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (img *Image) rvaU16(rva uint32) (uint16, error) {
+	b, err := img.ReadRVA(rva, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+// rvaString reads a NUL-terminated ANSI string at rva
+func (img *Image) rvaString(rva uint32) (string, error) {
+	offset, err := img.RVAToOffset(rva)
+	if err != nil {
+		return "", err
+	}
+	if uint64(offset) >= uint64(len(img.data)) {
+		return "", formatError("string at RVA 0x%X is out of bounds", rva)
+	}
+	rest := img.data[offset:]
+	if len(rest) > maxNameLength {
+		rest = rest[:maxNameLength]
+	}
+	for i, c := range rest {
+		if c == 0 {
+			return string(rest[:i]), nil
+		}
+	}
+	return "", formatError("unterminated string at RVA 0x%X", rva)
+}
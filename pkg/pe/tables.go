@@ -0,0 +1,366 @@
+package pe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Export is one entry of the export address table
+type Export struct {
+	// Name is empty for exports by ordinal only
+	Name string
+	// Ordinal is the biased ordinal (index + OrdinalBase)
+	Ordinal uint32
+	RVA     uint32
+	// Forward is the "dll.function" target of a forwarded export; RVA then points at the string
+	Forward string
+}
+
+// ExportTable is the parsed export directory
+type ExportTable struct {
+	Name        string
+	OrdinalBase uint32
+	// Functions is the raw export address table, indexed by ordinal - OrdinalBase
+	Functions []uint32
+	Exports   []Export
+
+	directory DataDirectory
+	names     map[string]uint32 // name -> index into Functions
+}
+
+// Exports parses the export directory. It returns nil and no error when the image has none.
+func (img *Image) Exports() (*ExportTable, error) {
+	dir := img.Directory(DirectoryExport)
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return nil, nil
+	}
+	header, err := img.ReadRVA(dir.VirtualAddress, 40)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &ExportTable{
+		OrdinalBase: binary.LittleEndian.Uint32(header[16:]),
+		directory:   dir,
+		names:       make(map[string]uint32),
+	}
+	if nameRVA := binary.LittleEndian.Uint32(header[12:]); nameRVA != 0 {
+		table.Name, _ = img.rvaString(nameRVA)
+	}
+	numberOfFunctions := binary.LittleEndian.Uint32(header[20:])
+	numberOfNames := binary.LittleEndian.Uint32(header[24:])
+	if numberOfFunctions > maxExports || numberOfNames > maxExports {
+		return nil, formatError("export directory claims %d functions and %d names", numberOfFunctions, numberOfNames)
+	}
+	addressTable := binary.LittleEndian.Uint32(header[28:])
+	nameTable := binary.LittleEndian.Uint32(header[32:])
+	ordinalTable := binary.LittleEndian.Uint32(header[36:])
+
+	table.Functions = make([]uint32, numberOfFunctions)
+	for i := range table.Functions {
+		if table.Functions[i], err = img.rvaU32(addressTable + uint32(i)*4); err != nil {
+			return nil, err
+		}
+	}
+
+	namesByIndex := make(map[uint32]string, numberOfNames)
+	for i := uint32(0); i < numberOfNames; i++ {
+		nameRVA, err := img.rvaU32(nameTable + i*4)
+		if err != nil {
+			return nil, err
+		}
+		index, err := img.rvaU16(ordinalTable + i*2)
+		if err != nil {
+			return nil, err
+		}
+		name, err := img.rvaString(nameRVA)
+		if err != nil {
+			return nil, err
+		}
+		table.names[name] = uint32(index)
+		if _, seen := namesByIndex[uint32(index)]; !seen {
+			namesByIndex[uint32(index)] = name
+		}
+	}
+
+	for i, rva := range table.Functions {
+		if rva == 0 {
+			continue
+		}
+		export := Export{Name: namesByIndex[uint32(i)], Ordinal: table.OrdinalBase + uint32(i), RVA: rva}
+		if table.IsForwarder(rva) {
+			export.Forward, _ = img.rvaString(rva)
+		}
+		table.Exports = append(table.Exports, export)
+	}
+	return table, nil
+}
+
+// IsForwarder reports whether rva points inside the export directory, i.e. at a forwarder string
+func (t *ExportTable) IsForwarder(rva uint32) bool {
+	return rva >= t.directory.VirtualAddress && rva-t.directory.VirtualAddress < t.directory.Size
+}
+
+// ByName returns the index into Functions of the export called name
+func (t *ExportTable) ByName(name string) (uint32, bool) {
+	index, ok := t.names[name]
+	return index, ok
+}
+
+// FunctionRVA returns the RVA at index in the export address table, or 0 when the index is
+// out of range, the slot is empty or the export is forwarded
+func (t *ExportTable) FunctionRVA(index uint32) uint32 {
+	if index >= uint32(len(t.Functions)) {
+		return 0
+	}
+	rva := t.Functions[index]
+	if t.IsForwarder(rva) {
+		return 0
+	}
+	return rva
+}
+
+// SortedByRVA returns the exports ordered by address
+func (t *ExportTable) SortedByRVA() []Export {
+	sorted := append([]Export(nil), t.Exports...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].RVA < sorted[j].RVA })
+	return sorted
+}
+
+// Import is one imported function
+type Import struct {
+	// Name is empty for imports by ordinal
+	Name    string
+	Hint    uint16
+	Ordinal uint32
+	// IATRVA is the RVA of the import address table slot for this function
+	IATRVA uint32
+	// Value is the current IAT slot contents: the bound address for a loaded image, the
+	// unbound thunk for a file
+	Value uint64
+}
+
+// ImportDescriptor is the set of functions imported from one DLL
+type ImportDescriptor struct {
+	DLL       string
+	Functions []Import
+}
+
+// Imports parses the import directory. Names are read from the import name table; when an
+// image has none, the IAT is used, which only holds names before binding.
+func (img *Image) Imports() ([]ImportDescriptor, error) {
+	dir := img.Directory(DirectoryImport)
+	if dir.VirtualAddress == 0 {
+		return nil, nil
+	}
+
+	thunkSize := uint32(4)
+	ordinalFlag := uint64(1) << 31
+	if img.Is64() {
+		thunkSize = 8
+		ordinalFlag = 1 << 63
+	}
+
+	var descriptors []ImportDescriptor
+	for i := uint32(0); i < maxImportDescriptors; i++ {
+		raw, err := img.ReadRVA(dir.VirtualAddress+i*20, 20)
+		if err != nil {
+			return descriptors, err
+		}
+		originalFirstThunk := binary.LittleEndian.Uint32(raw[0:])
+		nameRVA := binary.LittleEndian.Uint32(raw[12:])
+		firstThunk := binary.LittleEndian.Uint32(raw[16:])
+		if nameRVA == 0 && firstThunk == 0 {
+			break
+		}
+
+		descriptor := ImportDescriptor{}
+		if descriptor.DLL, err = img.rvaString(nameRVA); err != nil {
+			return descriptors, err
+		}
+
+		lookup := originalFirstThunk
+		if lookup == 0 {
+			lookup = firstThunk
+		}
+		hasNames := originalFirstThunk != 0 || !img.mapped
+		for j := uint32(0); j < maxImportThunks; j++ {
+			thunk, err := img.thunk(lookup+j*thunkSize, thunkSize)
+			if err != nil {
+				return descriptors, err
+			}
+			if thunk == 0 {
+				break
+			}
+			entry := Import{IATRVA: firstThunk + j*thunkSize}
+			if entry.Value, err = img.thunk(entry.IATRVA, thunkSize); err != nil {
+				return descriptors, err
+			}
+			if hasNames {
+				if thunk&ordinalFlag != 0 {
+					entry.Ordinal = uint32(thunk & 0xFFFF)
+				} else {
+					hintRVA := uint32(thunk & 0x7FFFFFFF)
+					entry.Hint, _ = img.rvaU16(hintRVA)
+					entry.Name, _ = img.rvaString(hintRVA + 2)
+				}
+			}
+			descriptor.Functions = append(descriptor.Functions, entry)
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors, nil
+}
+
+func (img *Image) thunk(rva uint32, size uint32) (uint64, error) {
+	b, err := img.ReadRVA(rva, size)
+	if err != nil {
+		return 0, err
+	}
+	if size == 8 {
+		return binary.LittleEndian.Uint64(b), nil
+	}
+	return uint64(binary.LittleEndian.Uint32(b)), nil
+}
+
+// Base relocation types
+const (
+	RelBasedAbsolute = 0
+	RelBasedHighLow  = 3
+	RelBasedDir64    = 10
+)
+
+// Relocation is one base relocation entry
+type Relocation struct {
+	RVA  uint32
+	Type uint16
+}
+
+// Relocations parses the base relocation directory, skipping IMAGE_REL_BASED_ABSOLUTE padding
+func (img *Image) Relocations() ([]Relocation, error) {
+	dir := img.Directory(DirectoryBaseReloc)
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return nil, nil
+	}
+	data, err := img.ReadRVA(dir.VirtualAddress, dir.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	var relocations []Relocation
+	for offset := 0; offset+8 <= len(data); {
+		pageRVA := binary.LittleEndian.Uint32(data[offset:])
+		blockSize := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if blockSize < 8 || offset+blockSize > len(data) {
+			return relocations, formatError("relocation block at 0x%X has size %d", offset, blockSize)
+		}
+		for entry := offset + 8; entry+2 <= offset+blockSize; entry += 2 {
+			value := binary.LittleEndian.Uint16(data[entry:])
+			if relType := value >> 12; relType != RelBasedAbsolute {
+				relocations = append(relocations, Relocation{RVA: pageRVA + uint32(value&0xFFF), Type: relType})
+			}
+		}
+		offset += blockSize
+	}
+	return relocations, nil
+}
+
+// RichEntry is one tool record of the Rich header
+type RichEntry struct {
+	ProductID uint16
+	Build     uint16
+	Count     uint32
+}
+
+// RichHeader is the decoded linker Rich header between the DOS stub and the PE header
+type RichHeader struct {
+	Key     uint32
+	Entries []RichEntry
+}
+
+const (
+	richSignature = 0x68636952 // "Rich"
+	dansSignature = 0x536E6144 // "DanS"
+)
+
+// RichHeader decodes the Rich header. It returns nil and no error when the image has none.
+func (img *Image) RichHeader() (*RichHeader, error) {
+	stub, err := img.slice(0, img.Dos.Lfanew)
+	if err != nil {
+		return nil, err
+	}
+
+	end := -1
+	for offset := len(stub) - 8; offset >= 0x40; offset -= 4 {
+		if binary.LittleEndian.Uint32(stub[offset:]) == richSignature {
+			end = offset
+			break
+		}
+	}
+	if end < 0 {
+		return nil, nil
+	}
+	key := binary.LittleEndian.Uint32(stub[end+4:])
+
+	start := -1
+	for offset := end - 4; offset >= 0x40; offset -= 4 {
+		if binary.LittleEndian.Uint32(stub[offset:])^key == dansSignature {
+			start = offset
+			break
+		}
+	}
+	if start < 0 {
+		return nil, formatError("Rich header without a DanS marker")
+	}
+
+	header := &RichHeader{Key: key}
+	// DanS is followed by three zero (xor key) padding dwords, then (comp.id, count) pairs
+	for offset := start + 16; offset+8 <= end; offset += 8 {
+		compID := binary.LittleEndian.Uint32(stub[offset:]) ^ key
+		header.Entries = append(header.Entries, RichEntry{
+			ProductID: uint16(compID >> 16),
+			Build:     uint16(compID),
+			Count:     binary.LittleEndian.Uint32(stub[offset+4:]) ^ key,
+		})
+	}
+	return header, nil
+}
+
+// Relocate applies the base relocations of a raw file image in place as if it were loaded at
+// newBase, so its section data can be compared byte for byte with the mapped image.
+// Relocations whose target has no file data are skipped.
+func (img *Image) Relocate(newBase uint64) error {
+	if img.mapped {
+		return fmt.Errorf("cannot relocate a mapped image")
+	}
+	relocations, err := img.Relocations()
+	if err != nil {
+		return err
+	}
+	delta := newBase - img.Optional.ImageBase
+	if delta == 0 {
+		return nil
+	}
+
+	for _, relocation := range relocations {
+		switch relocation.Type {
+		case RelBasedDir64:
+			b, err := img.ReadRVA(relocation.RVA, 8)
+			if err != nil {
+				continue // target has no file data (e.g. uninitialized data)
+			}
+			binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)+delta)
+		case RelBasedHighLow:
+			b, err := img.ReadRVA(relocation.RVA, 4)
+			if err != nil {
+				continue // target has no file data (e.g. uninitialized data)
+			}
+			binary.LittleEndian.PutUint32(b, binary.LittleEndian.Uint32(b)+uint32(delta))
+		default:
+			return formatError("unsupported relocation type %d at RVA 0x%X", relocation.Type, relocation.RVA)
+		}
+	}
+	img.Optional.ImageBase = newBase
+	return nil
+}
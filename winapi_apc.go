@@ -0,0 +1,188 @@
+// Package winapi - APC Execution Module
+// Provides APC queueing into existing threads via NtQueueApcThread / NtQueueApcThreadEx
+package winapi
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// ApcMode selects how an APC is queued
+type ApcMode int
+
+const (
+	// ApcClassic queues a normal user APC; it only runs once the thread enters an alertable wait
+	ApcClassic ApcMode = iota
+	// ApcSpecialUser queues a special user APC (Windows 10 1903+), delivered on the thread's
+	// next return to user mode without requiring an alertable wait
+	ApcSpecialUser
+)
+
+// ApcOptions configures how a payload is queued to the threads of a target
+type ApcOptions struct {
+	Mode ApcMode
+	// Argument is passed as the first APC argument
+	Argument uintptr
+	// MaxThreads caps how many threads receive the APC; 0 means 1. A classic APC runs once
+	// per thread it is queued to, so raising this trades duplicate execution for reliability.
+	MaxThreads int
+	// IncludeUnlikely also queues to threads that don't look alertable once the likely
+	// candidates are exhausted
+	IncludeUnlikely bool
+	// ForceAlert suspends each thread, queues the APC and resumes it with NtAlertResumeThread,
+	// which alerts the thread so a classic APC is delivered if it is in an alertable wait
+	ForceAlert bool
+}
+
+// ApcCandidate describes a thread of the target and how likely it is to run a classic APC
+type ApcCandidate struct {
+	ThreadId     uintptr
+	StartAddress uintptr
+	State        uint32
+	WaitReason   uint32
+	// LikelyAlertable is a heuristic: the thread is waiting for a reason that alertable waits
+	// (SleepEx, WaitForSingleObjectEx, GetQueuedCompletionStatusEx, ...) are reported with.
+	// Alertability itself is not visible from outside the process.
+	LikelyAlertable bool
+}
+
+func likelyAlertable(thread SYSTEM_THREAD_INFORMATION) bool {
+	if thread.ThreadState != ThreadStateWaiting {
+		return false
+	}
+	switch thread.WaitReason {
+	case WaitReasonUserRequest, WaitReasonDelayExecution, WaitReasonWrQueue, WaitReasonWrAlertByThreadId:
+		return true
+	}
+	return false
+}
+
+// FindApcCandidates lists the threads of pid, likely-alertable threads first
+func FindApcCandidates(pid uint32) ([]ApcCandidate, error) {
+	threads, err := processThreads(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]ApcCandidate, 0, len(threads))
+	for _, thread := range threads {
+		candidates = append(candidates, ApcCandidate{
+			ThreadId:        thread.ClientId.UniqueThread,
+			StartAddress:    thread.StartAddress,
+			State:           thread.ThreadState,
+			WaitReason:      thread.WaitReason,
+			LikelyAlertable: likelyAlertable(thread),
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].LikelyAlertable && !candidates[j].LikelyAlertable
+	})
+
+	return candidates, nil
+}
+
+// QueueApc queues routine(arg, 0, 0) to threadHandle, which needs THREAD_SET_CONTEXT
+func QueueApc(threadHandle uintptr, routine uintptr, arg uintptr, mode ApcMode) error {
+	var status uintptr
+	var err error
+
+	switch mode {
+	case ApcClassic:
+		status, err = NtQueueApcThread(threadHandle, routine, arg, 0, 0)
+	case ApcSpecialUser:
+		status, err = NtQueueApcThreadEx(threadHandle, QUEUE_USER_APC_FLAGS_SPECIAL_USER_APC, routine, arg, 0, 0)
+	default:
+		return fmt.Errorf("unknown APC mode %d", mode)
+	}
+
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("APC queueing failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// queueApcToThread opens threadId and queues routine to it, optionally forcing an alert
+func queueApcToThread(threadId uintptr, routine uintptr, opts ApcOptions) error {
+	access := uintptr(THREAD_SET_CONTEXT | THREAD_QUERY_LIMITED_INFORMATION)
+	if opts.ForceAlert {
+		access |= THREAD_SUSPEND_RESUME
+	}
+
+	var threadHandle uintptr
+	clientId := CLIENT_ID{UniqueThread: threadId}
+	objAttr := OBJECT_ATTRIBUTES{Length: uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{}))}
+	status, err := NtOpenThread(&threadHandle, access, uintptr(unsafe.Pointer(&objAttr)), uintptr(unsafe.Pointer(&clientId)))
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtOpenThread failed for TID %d: %v (%s)", threadId, err, FormatNTStatus(status))
+	}
+	defer NtClose(threadHandle)
+
+	if !opts.ForceAlert {
+		return QueueApc(threadHandle, routine, opts.Argument, opts.Mode)
+	}
+
+	var suspendCount uintptr
+	status, err = NtSuspendThread(threadHandle, &suspendCount)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtSuspendThread failed for TID %d: %v (%s)", threadId, err, FormatNTStatus(status))
+	}
+
+	queueErr := QueueApc(threadHandle, routine, opts.Argument, opts.Mode)
+
+	status, err = NtAlertResumeThread(threadHandle, &suspendCount)
+	if err != nil || status != STATUS_SUCCESS {
+		debug.Printfln("APC", "Warning: NtAlertResumeThread failed for TID %d: %v (%s)\n", threadId, err, FormatNTStatus(status))
+		NtResumeThread(threadHandle, &suspendCount)
+	}
+
+	return queueErr
+}
+
+// QueueApcToProcess queues routine to up to opts.MaxThreads threads of pid and returns the
+// ids of the threads it was queued to. routine must already be present in the target.
+func QueueApcToProcess(pid uint32, routine uintptr, opts ApcOptions) ([]uintptr, error) {
+	if routine == 0 {
+		return nil, fmt.Errorf("invalid APC routine")
+	}
+
+	candidates, err := FindApcCandidates(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	maxThreads := opts.MaxThreads
+	if maxThreads <= 0 {
+		maxThreads = 1
+	}
+
+	var queued []uintptr
+	var lastErr error
+	for _, candidate := range candidates {
+		if len(queued) >= maxThreads {
+			break
+		}
+		if !candidate.LikelyAlertable && !opts.IncludeUnlikely && opts.Mode == ApcClassic {
+			continue
+		}
+
+		if err := queueApcToThread(candidate.ThreadId, routine, opts); err != nil {
+			debug.Printfln("APC", "Skipping TID %d: %v\n", candidate.ThreadId, err)
+			lastErr = err
+			continue
+		}
+		debug.Printfln("APC", "Queued APC to TID %d (alertable guess: %v)\n", candidate.ThreadId, candidate.LikelyAlertable)
+		queued = append(queued, candidate.ThreadId)
+	}
+
+	if len(queued) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("no thread of PID %d accepted the APC: %v", pid, lastErr)
+		}
+		return nil, fmt.Errorf("no suitable thread found in PID %d", pid)
+	}
+	return queued, nil
+}
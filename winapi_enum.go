@@ -0,0 +1,470 @@
+// Package winapi - System Enumeration Module
+// Provides process, thread and kernel driver enumeration on top of NtQuerySystemInformation, and
+// remote module listing through the PEB loader data
+package winapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// querySystemInformation returns the full buffer for infoClass, growing it until the
+// kernel stops reporting STATUS_INFO_LENGTH_MISMATCH (the data can grow between calls)
+func querySystemInformation(infoClass uintptr) ([]byte, error) {
+	size := uintptr(0x10000)
+
+	for attempt := 0; attempt < 8; attempt++ {
+		buffer := make([]byte, size)
+		var returnLength uintptr
+
+		status, err := NtQuerySystemInformation(infoClass, unsafe.Pointer(&buffer[0]), size, &returnLength)
+		if err != nil {
+			return nil, fmt.Errorf("NtQuerySystemInformation error: %v", err)
+		}
+
+		switch status {
+		case STATUS_SUCCESS:
+			return buffer[:returnLength], nil
+		case STATUS_INFO_LENGTH_MISMATCH, STATUS_BUFFER_TOO_SMALL:
+			if returnLength > size {
+				size = returnLength + 0x4000
+			} else {
+				size *= 2
+			}
+		default:
+			return nil, fmt.Errorf("NtQuerySystemInformation failed: %s", FormatNTStatus(status))
+		}
+	}
+
+	return nil, fmt.Errorf("NtQuerySystemInformation kept growing past 0x%X bytes", size)
+}
+
+// walkProcessEntries calls fn for every SYSTEM_PROCESS_INFORMATION entry in buffer together
+// with that entry's thread array. Returning false from fn stops the walk.
+func walkProcessEntries(buffer []byte, fn func(proc *SYSTEM_PROCESS_INFORMATION, threads []SYSTEM_THREAD_INFORMATION) bool) {
+	procSize := unsafe.Sizeof(SYSTEM_PROCESS_INFORMATION{})
+	threadSize := unsafe.Sizeof(SYSTEM_THREAD_INFORMATION{})
+	offset := uintptr(0)
+
+	for offset+procSize <= uintptr(len(buffer)) {
+		proc := (*SYSTEM_PROCESS_INFORMATION)(unsafe.Pointer(&buffer[offset]))
+
+		var threads []SYSTEM_THREAD_INFORMATION
+		threadsEnd := offset + procSize + uintptr(proc.NumberOfThreads)*threadSize
+		if proc.NumberOfThreads > 0 && threadsEnd <= uintptr(len(buffer)) {
+			threads = unsafe.Slice((*SYSTEM_THREAD_INFORMATION)(unsafe.Pointer(&buffer[offset+procSize])), proc.NumberOfThreads)
+		}
+
+		if !fn(proc, threads) {
+			return
+		}
+
+		if proc.NextEntryOffset == 0 {
+			return
+		}
+		next := offset + uintptr(proc.NextEntryOffset)
+		if next <= offset {
+			return
+		}
+		offset = next
+	}
+}
+
+// processThreads returns a copy of the thread entries of pid
+func processThreads(pid uint32) ([]SYSTEM_THREAD_INFORMATION, error) {
+	buffer, err := querySystemInformation(SystemProcessInformation)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []SYSTEM_THREAD_INFORMATION
+	found := false
+	walkProcessEntries(buffer, func(proc *SYSTEM_PROCESS_INFORMATION, threads []SYSTEM_THREAD_INFORMATION) bool {
+		if proc.UniqueProcessId != uintptr(pid) {
+			return true
+		}
+		found = true
+		result = append(result, threads...)
+		return false
+	})
+
+	if !found {
+		return nil, fmt.Errorf("process %d not found", pid)
+	}
+	return result, nil
+}
+
+// ProcessEntry is one process of the SystemProcessInformation snapshot
+type ProcessEntry struct {
+	PID         uint32
+	ParentPID   uint32
+	Name        string
+	SessionId   uint32
+	ThreadCount uint32
+	HandleCount uint32
+	// CreateTime is zero for the idle and system processes
+	CreateTime time.Time
+}
+
+// EnumerateProcesses returns a snapshot of all running processes. The parent PID is the one
+// recorded at creation; that process may have exited and the PID been reused since.
+func EnumerateProcesses() ([]ProcessEntry, error) {
+	buffer, err := querySystemInformation(SystemProcessInformation)
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []ProcessEntry
+	walkProcessEntries(buffer, func(proc *SYSTEM_PROCESS_INFORMATION, _ []SYSTEM_THREAD_INFORMATION) bool {
+		entry := ProcessEntry{
+			PID:         uint32(proc.UniqueProcessId),
+			ParentPID:   uint32(proc.InheritedFromUniqueProcessId),
+			SessionId:   proc.SessionId,
+			ThreadCount: proc.NumberOfThreads,
+			HandleCount: proc.HandleCount,
+			CreateTime:  filetimeToTime(proc.CreateTime),
+		}
+		switch {
+		case proc.ImageName.Buffer != nil && proc.ImageName.Length > 0:
+			entry.Name = utf16ToString(proc.ImageName.Buffer, int(proc.ImageName.Length/2))
+		case entry.PID == 0:
+			entry.Name = "System Idle Process"
+		}
+		processes = append(processes, entry)
+		return true
+	})
+	return processes, nil
+}
+
+// ThreadEntry is one thread of a process
+type ThreadEntry struct {
+	TID        uintptr
+	State      uint32 // ThreadState* (KTHREAD_STATE)
+	WaitReason uint32 // WaitReason* (KWAIT_REASON), meaningful when State is ThreadStateWaiting
+	Priority   int32
+	CreateTime time.Time
+	// StartAddress is the kernel-recorded start address, for user threads usually
+	// ntdll!RtlUserThreadStart
+	StartAddress uintptr
+	// Win32StartAddress is the routine passed to the thread creation call; it is 0 when the
+	// thread could not be opened with THREAD_QUERY_INFORMATION
+	Win32StartAddress uintptr
+}
+
+// EnumerateThreads returns the threads of pid. The Win32 start address of each thread is
+// queried with NtQueryInformationThread(ThreadQuerySetWin32StartAddress) where access allows.
+func EnumerateThreads(pid uint32) ([]ThreadEntry, error) {
+	threads, err := processThreads(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ThreadEntry, 0, len(threads))
+	for _, thread := range threads {
+		entries = append(entries, ThreadEntry{
+			TID:               thread.ClientId.UniqueThread,
+			State:             thread.ThreadState,
+			WaitReason:        thread.WaitReason,
+			Priority:          thread.Priority,
+			CreateTime:        filetimeToTime(thread.CreateTime),
+			StartAddress:      thread.StartAddress,
+			Win32StartAddress: threadWin32StartAddress(thread.ClientId.UniqueThread),
+		})
+	}
+	return entries, nil
+}
+
+// threadWin32StartAddress returns the Win32 start address of threadId, or 0 on failure
+func threadWin32StartAddress(threadId uintptr) uintptr {
+	var threadHandle uintptr
+	clientId := CLIENT_ID{UniqueThread: threadId}
+	objAttr := OBJECT_ATTRIBUTES{Length: uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{}))}
+	status, err := NtOpenThread(&threadHandle, THREAD_QUERY_INFORMATION, uintptr(unsafe.Pointer(&objAttr)), uintptr(unsafe.Pointer(&clientId)))
+	if err != nil || status != STATUS_SUCCESS {
+		return 0
+	}
+	defer NtClose(threadHandle)
+
+	var startAddress uintptr
+	var returnLength uintptr
+	status, err = NtQueryInformationThread(threadHandle, ThreadQuerySetWin32StartAddress, unsafe.Pointer(&startAddress), unsafe.Sizeof(startAddress), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0
+	}
+	return startAddress
+}
+
+// filetimeToTime converts a FILETIME (100ns intervals since 1601) to a time.Time
+func filetimeToTime(filetime int64) time.Time {
+	const unixEpoch = 116444736000000000 // 1970-01-01 as a FILETIME
+	if filetime <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, (filetime-unixEpoch)*100)
+}
+
+// DriverEntry is one kernel module of the SystemModuleInformation snapshot
+type DriverEntry struct {
+	Name string
+	// Path is the NT path as recorded by the loader, e.g. \SystemRoot\system32\ntoskrnl.exe
+	Path string
+	// ImageBase is 0 unless the caller holds SeDebugPrivilege at high integrity; Windows
+	// hides kernel addresses from everyone else
+	ImageBase      uintptr
+	ImageSize      uint32
+	LoadOrderIndex uint16
+}
+
+// Layout of RTL_PROCESS_MODULE_INFORMATION on x64
+const (
+	rtlModuleEntrySize      = 0x128
+	rtlModuleImageBase      = 0x10
+	rtlModuleImageSize      = 0x18
+	rtlModuleLoadOrderIndex = 0x20
+	rtlModuleFileNameOffset = 0x26
+	rtlModuleFullPathName   = 0x28
+	rtlModulePathLength     = 256
+)
+
+// EnumerateDrivers returns the loaded kernel modules (ntoskrnl, hal and drivers) in load order
+func EnumerateDrivers() ([]DriverEntry, error) {
+	buffer, err := querySystemInformation(SystemModuleInformation)
+	if err != nil {
+		return nil, err
+	}
+	if len(buffer) < 8 {
+		return nil, fmt.Errorf("SystemModuleInformation returned %d bytes", len(buffer))
+	}
+
+	count := int(binary.LittleEndian.Uint32(buffer))
+	drivers := make([]DriverEntry, 0, count)
+	for i := 0; i < count; i++ {
+		offset := 8 + i*rtlModuleEntrySize // NumberOfModules is padded to a pointer
+		if offset+rtlModuleEntrySize > len(buffer) {
+			break
+		}
+		entry := buffer[offset : offset+rtlModuleEntrySize]
+
+		pathBytes := entry[rtlModuleFullPathName : rtlModuleFullPathName+rtlModulePathLength]
+		if end := bytes.IndexByte(pathBytes, 0); end >= 0 {
+			pathBytes = pathBytes[:end]
+		}
+		path := string(pathBytes)
+		name := path
+		if nameOffset := int(binary.LittleEndian.Uint16(entry[rtlModuleFileNameOffset:])); nameOffset < len(path) {
+			name = path[nameOffset:]
+		}
+
+		drivers = append(drivers, DriverEntry{
+			Name:           name,
+			Path:           path,
+			ImageBase:      uintptr(binary.LittleEndian.Uint64(entry[rtlModuleImageBase:])),
+			ImageSize:      binary.LittleEndian.Uint32(entry[rtlModuleImageSize:]),
+			LoadOrderIndex: binary.LittleEndian.Uint16(entry[rtlModuleLoadOrderIndex:]),
+		})
+	}
+	return drivers, nil
+}
+
+// RemoteModule describes a module loaded in another process, read from its PEB loader list
+type RemoteModule struct {
+	Name        string
+	Path        string
+	BaseAddress uintptr
+	Size        uint32
+	// Wow64 marks a module from the 32-bit loader list of a WOW64 process
+	Wow64 bool
+}
+
+// Offsets into PEB, PEB_LDR_DATA and LDR_DATA_TABLE_ENTRY on x64
+const (
+	pebLdrOffset              = 0x18
+	ldrInLoadOrderListOffset  = 0x10
+	ldrEntryDllBaseOffset     = 0x30
+	ldrEntrySizeOfImageOffset = 0x40
+	ldrEntryFullNameOffset    = 0x48
+	ldrEntryBaseNameOffset    = 0x58
+	ldrEntryReadSize          = 0x68
+	maxRemoteModules          = 4096
+)
+
+// Offsets into PEB32, PEB_LDR_DATA32 and LDR_DATA_TABLE_ENTRY32 of a WOW64 process
+const (
+	peb32LdrOffset              = 0x0C
+	ldr32InLoadOrderListOffset  = 0x0C
+	ldr32EntryDllBaseOffset     = 0x18
+	ldr32EntrySizeOfImageOffset = 0x20
+	ldr32EntryFullNameOffset    = 0x24
+	ldr32EntryBaseNameOffset    = 0x2C
+	ldr32EntryReadSize          = 0x34
+)
+
+func readRemotePointer(processHandle uintptr, address uintptr) (uintptr, error) {
+	var value uintptr
+	var bytesRead uintptr
+	status, err := NtReadVirtualMemory(processHandle, address, unsafe.Pointer(&value), unsafe.Sizeof(value), &bytesRead)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtReadVirtualMemory failed at 0x%X: %v (%s)", address, err, FormatNTStatus(status))
+	}
+	return value, nil
+}
+
+// readRemoteUnicodeString reads length bytes of UTF-16 text at buffer in processHandle. The
+// UNICODE_STRING fields are passed separately because its Buffer points into the remote
+// address space and must never be held in a Go pointer.
+func readRemoteUnicodeString(processHandle uintptr, length uint16, buffer uintptr) string {
+	if length < 2 || buffer == 0 {
+		return ""
+	}
+	chars := make([]uint16, length/2)
+	var bytesRead uintptr
+	status, err := NtReadVirtualMemory(processHandle, buffer, unsafe.Pointer(&chars[0]), uintptr(len(chars)*2), &bytesRead)
+	if err != nil || status != STATUS_SUCCESS {
+		return ""
+	}
+	return utf16ToString(&chars[0], len(chars))
+}
+
+// remotePEBAddress returns the PEB address of processHandle
+func remotePEBAddress(processHandle uintptr) (uintptr, error) {
+	var pbi PROCESS_BASIC_INFORMATION
+	var returnLength uintptr
+	status, err := NtQueryInformationProcess(processHandle, ProcessBasicInformation, unsafe.Pointer(&pbi), unsafe.Sizeof(pbi), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtQueryInformationProcess failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	if pbi.PebBaseAddress == 0 {
+		return 0, fmt.Errorf("process has no PEB")
+	}
+	return pbi.PebBaseAddress, nil
+}
+
+// readRemoteModules walks the InLoadOrderModuleList of processHandle, which needs
+// PROCESS_QUERY_LIMITED_INFORMATION and PROCESS_VM_READ
+func readRemoteModules(processHandle uintptr) ([]RemoteModule, error) {
+	peb, err := remotePEBAddress(processHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	ldr, err := readRemotePointer(processHandle, peb+pebLdrOffset)
+	if err != nil {
+		return nil, err
+	}
+	if ldr == 0 {
+		return nil, fmt.Errorf("loader data not initialized yet")
+	}
+
+	head := ldr + ldrInLoadOrderListOffset
+	link, err := readRemotePointer(processHandle, head)
+	if err != nil {
+		return nil, err
+	}
+
+	var modules []RemoteModule
+	for link != head && link != 0 && len(modules) < maxRemoteModules {
+		var entry [ldrEntryReadSize]byte
+		var bytesRead uintptr
+		status, err := NtReadVirtualMemory(processHandle, link, unsafe.Pointer(&entry[0]), ldrEntryReadSize, &bytesRead)
+		if err != nil || status != STATUS_SUCCESS {
+			return modules, fmt.Errorf("failed to read loader entry at 0x%X: %v (%s)", link, err, FormatNTStatus(status))
+		}
+
+		modules = append(modules, RemoteModule{
+			Name: readRemoteUnicodeString(processHandle,
+				binary.LittleEndian.Uint16(entry[ldrEntryBaseNameOffset:]),
+				uintptr(binary.LittleEndian.Uint64(entry[ldrEntryBaseNameOffset+8:]))),
+			Path: readRemoteUnicodeString(processHandle,
+				binary.LittleEndian.Uint16(entry[ldrEntryFullNameOffset:]),
+				uintptr(binary.LittleEndian.Uint64(entry[ldrEntryFullNameOffset+8:]))),
+			BaseAddress: uintptr(binary.LittleEndian.Uint64(entry[ldrEntryDllBaseOffset:])),
+			Size:        binary.LittleEndian.Uint32(entry[ldrEntrySizeOfImageOffset:]),
+		})
+
+		link = uintptr(binary.LittleEndian.Uint64(entry[0:])) // InLoadOrderLinks.Flink
+	}
+
+	return modules, nil
+}
+
+// readRemoteModules32 walks the 32-bit InLoadOrderModuleList of a WOW64 process whose PEB32
+// is at peb32. All pointers in these structures are 32 bits wide.
+func readRemoteModules32(processHandle uintptr, peb32 uintptr) ([]RemoteModule, error) {
+	var ldr uint32
+	var bytesRead uintptr
+	status, err := NtReadVirtualMemory(processHandle, peb32+peb32LdrOffset, unsafe.Pointer(&ldr), 4, &bytesRead)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("failed to read PEB32 loader data: %v (%s)", err, FormatNTStatus(status))
+	}
+	if ldr == 0 {
+		return nil, fmt.Errorf("32-bit loader data not initialized yet")
+	}
+
+	head := uintptr(ldr) + ldr32InLoadOrderListOffset
+	var flink uint32
+	status, err = NtReadVirtualMemory(processHandle, head, unsafe.Pointer(&flink), 4, &bytesRead)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("failed to read the 32-bit module list head: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	var modules []RemoteModule
+	for link := uintptr(flink); link != head && link != 0 && len(modules) < maxRemoteModules; {
+		var entry [ldr32EntryReadSize]byte
+		status, err := NtReadVirtualMemory(processHandle, link, unsafe.Pointer(&entry[0]), ldr32EntryReadSize, &bytesRead)
+		if err != nil || status != STATUS_SUCCESS {
+			return modules, fmt.Errorf("failed to read 32-bit loader entry at 0x%X: %v (%s)", link, err, FormatNTStatus(status))
+		}
+
+		modules = append(modules, RemoteModule{
+			Name: readRemoteUnicodeString(processHandle,
+				binary.LittleEndian.Uint16(entry[ldr32EntryBaseNameOffset:]),
+				uintptr(binary.LittleEndian.Uint32(entry[ldr32EntryBaseNameOffset+4:]))),
+			Path: readRemoteUnicodeString(processHandle,
+				binary.LittleEndian.Uint16(entry[ldr32EntryFullNameOffset:]),
+				uintptr(binary.LittleEndian.Uint32(entry[ldr32EntryFullNameOffset+4:]))),
+			BaseAddress: uintptr(binary.LittleEndian.Uint32(entry[ldr32EntryDllBaseOffset:])),
+			Size:        binary.LittleEndian.Uint32(entry[ldr32EntrySizeOfImageOffset:]),
+			Wow64:       true,
+		})
+
+		link = uintptr(binary.LittleEndian.Uint32(entry[0:])) // InLoadOrderLinks.Flink
+	}
+
+	return modules, nil
+}
+
+// remoteWow64PEB returns the PEB32 address of a WOW64 process, or 0 for a native process
+func remoteWow64PEB(processHandle uintptr) (uintptr, error) {
+	var peb32 uintptr
+	var returnLength uintptr
+	status, err := NtQueryInformationProcess(processHandle, ProcessWow64Information, unsafe.Pointer(&peb32), unsafe.Sizeof(peb32), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtQueryInformationProcess(ProcessWow64Information) failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return peb32, nil
+}
+
+// EnumerateRemoteModules returns the modules loaded in processHandle, which needs
+// PROCESS_QUERY_LIMITED_INFORMATION and PROCESS_VM_READ, by walking the loader lists of its
+// PEB. For a WOW64 process the 32-bit modules come first, marked Wow64, followed by the native
+// 64-bit ones (ntdll and the wow64 layer).
+func EnumerateRemoteModules(processHandle uintptr) ([]RemoteModule, error) {
+	peb32, err := remoteWow64PEB(processHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	var modules []RemoteModule
+	if peb32 != 0 {
+		if modules, err = readRemoteModules32(processHandle, peb32); err != nil {
+			return modules, err
+		}
+	}
+
+	native, err := readRemoteModules(processHandle)
+	modules = append(modules, native...)
+	return modules, err
+}
@@ -0,0 +1,348 @@
+// Package winapi - Handle Enumeration Module
+// Provides system-wide handle enumeration with object type and name resolution
+package winapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/obf"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+const (
+	defaultHandleNameTimeout = 100 * time.Millisecond
+	// maxHungNameQueries bounds the query threads that survive NtTerminateThread; past it names
+	// of File objects, the only type whose name query blocks, are no longer resolved
+	maxHungNameQueries = 4
+	// objectTypeInformationSize is sizeof(OBJECT_TYPE_INFORMATION) on x64; the type name follows
+	objectTypeInformationSize = 0x68
+	objectTypeIndexOffset     = 0x5A
+)
+
+// HandleFilter narrows EnumerateHandles. The zero value returns every handle in the system
+// with its type name but without object names.
+type HandleFilter struct {
+	// PID restricts the result to handles owned by one process; 0 means all processes
+	PID uint32
+	// TypeName restricts the result to one object type, e.g. "Process", "File" or "Section";
+	// the comparison is case-insensitive
+	TypeName string
+	// ResolveNames duplicates each handle into the current process and queries its object name.
+	// This needs PROCESS_DUP_HANDLE on the owners and is much slower than type resolution.
+	ResolveNames bool
+	// NameTimeout bounds each name query; 0 means 100ms
+	NameTimeout time.Duration
+}
+
+// HandleEntry is one open handle
+type HandleEntry struct {
+	PID    uint32
+	Handle uintptr
+	// Object is the kernel address of the object; equal values mean the same object
+	Object        uintptr
+	GrantedAccess uint32
+	Attributes    uint32
+	TypeIndex     uint16
+	TypeName      string
+	// Name is empty when it was not requested, the object is unnamed or the query failed
+	Name string
+}
+
+// EnumerateHandles returns the open handles of the system that match filter, using
+// NtQuerySystemInformation(SystemExtendedHandleInformation).
+//
+// Name queries run on a native worker thread that is terminated after NameTimeout, because
+// NtQueryObject(ObjectNameInformation) blocks on a file object (typically a pipe) that has a
+// synchronous read pending in its owner.
+func EnumerateHandles(filter HandleFilter) ([]HandleEntry, error) {
+	buffer, err := querySystemInformation(SystemExtendedHandleInformation)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := 2 * unsafe.Sizeof(uintptr(0))
+	entrySize := unsafe.Sizeof(SYSTEM_HANDLE_TABLE_ENTRY_INFO_EX{})
+	if uintptr(len(buffer)) < headerSize {
+		return nil, fmt.Errorf("SystemExtendedHandleInformation returned %d bytes", len(buffer))
+	}
+	count := uintptr(binary.LittleEndian.Uint64(buffer))
+	if available := (uintptr(len(buffer)) - headerSize) / entrySize; count > available {
+		count = available
+	}
+	var table []SYSTEM_HANDLE_TABLE_ENTRY_INFO_EX
+	if count > 0 {
+		table = unsafe.Slice((*SYSTEM_HANDLE_TABLE_ENTRY_INFO_EX)(unsafe.Pointer(&buffer[headerSize])), count)
+	}
+
+	typeNames, err := objectTypeNames()
+	if err != nil {
+		debug.Printfln("HANDLES", "Object type names unavailable: %v\n", err)
+	}
+
+	resolver := &handleNameResolver{
+		timeout:   filter.NameTimeout,
+		processes: make(map[uint32]uintptr),
+	}
+	if resolver.timeout <= 0 {
+		resolver.timeout = defaultHandleNameTimeout
+	}
+	defer resolver.close()
+
+	var handles []HandleEntry
+	for _, raw := range table {
+		entry := HandleEntry{
+			PID:           uint32(raw.UniqueProcessId),
+			Handle:        raw.HandleValue,
+			Object:        raw.Object,
+			GrantedAccess: raw.GrantedAccess,
+			Attributes:    raw.HandleAttributes,
+			TypeIndex:     raw.ObjectTypeIndex,
+			TypeName:      typeNames[raw.ObjectTypeIndex],
+		}
+		if filter.PID != 0 && entry.PID != filter.PID {
+			continue
+		}
+		if filter.TypeName != "" && !strings.EqualFold(entry.TypeName, filter.TypeName) {
+			continue
+		}
+		if filter.ResolveNames {
+			entry.Name = resolver.name(entry)
+		}
+		handles = append(handles, entry)
+	}
+
+	debug.Printfln("HANDLES", "%d of %d handles matched\n", len(handles), len(table))
+	return handles, nil
+}
+
+// objectTypeNames maps object type indexes to names via NtQueryObject(ObjectTypesInformation)
+func objectTypeNames() (map[uint16]string, error) {
+	buffer, err := queryObject(0, ObjectTypesInformation)
+	if err != nil {
+		return nil, err
+	}
+	if len(buffer) < 8 {
+		return nil, fmt.Errorf("ObjectTypesInformation returned %d bytes", len(buffer))
+	}
+
+	numberOfTypes := binary.LittleEndian.Uint32(buffer)
+	names := make(map[uint16]string, numberOfTypes)
+	offset := 8 // NumberOfTypes, aligned to a pointer
+	for i := uint32(0); i < numberOfTypes && offset+objectTypeInformationSize <= len(buffer); i++ {
+		length := int(binary.LittleEndian.Uint16(buffer[offset:]))
+		maximumLength := int(binary.LittleEndian.Uint16(buffer[offset+2:]))
+		nameOffset := offset + objectTypeInformationSize
+		if nameOffset+length > len(buffer) {
+			break
+		}
+		// TypeIndex exists from Windows 8.1; before that indexes start at 2 in table order
+		index := uint16(buffer[offset+objectTypeIndexOffset])
+		if index == 0 {
+			index = uint16(i) + 2
+		}
+		if length >= 2 {
+			names[index] = utf16ToString((*uint16)(unsafe.Pointer(&buffer[nameOffset])), length/2)
+		}
+		offset = nameOffset + (maximumLength+7)&^7
+	}
+	return names, nil
+}
+
+// queryObject returns the full NtQueryObject buffer for infoClass
+func queryObject(handle uintptr, infoClass uintptr) ([]byte, error) {
+	size := uintptr(0x1000)
+	for attempt := 0; attempt < 8; attempt++ {
+		buffer := make([]byte, size)
+		var returnLength uintptr
+		status, err := NtQueryObject(handle, infoClass, unsafe.Pointer(&buffer[0]), size, &returnLength)
+		if err != nil {
+			return nil, fmt.Errorf("NtQueryObject error: %v", err)
+		}
+		switch status {
+		case STATUS_SUCCESS:
+			return buffer, nil
+		case STATUS_INFO_LENGTH_MISMATCH, STATUS_BUFFER_TOO_SMALL, STATUS_BUFFER_OVERFLOW:
+			if returnLength > size {
+				size = returnLength
+			} else {
+				size *= 2
+			}
+		default:
+			return nil, fmt.Errorf("NtQueryObject(%d) failed: %s", infoClass, FormatNTStatus(status))
+		}
+	}
+	return nil, fmt.Errorf("NtQueryObject(%d) kept growing past 0x%X bytes", infoClass, size)
+}
+
+// handleNameResolver duplicates handles out of their owners and queries their names. Each query
+// runs on a native thread so a blocked NtQueryObject can be terminated: the direct syscall stubs
+// never tell the Go scheduler they are in a syscall, so a goroutine stuck there would hold its P
+// for good.
+type handleNameResolver struct {
+	timeout time.Duration
+	// processes caches PROCESS_DUP_HANDLE handles per PID; 0 records a failed open
+	processes map[uint32]uintptr
+	// stub is the query trampoline calling routine (ntdll!NtQueryObject), region the parameter
+	// block and result buffer it uses
+	stub    uintptr
+	routine uintptr
+	region  uintptr
+	// hung counts queries whose thread could not be terminated; their memory is abandoned
+	hung int
+}
+
+// Layout of the name query region: the nameQueryStub parameter block, then the output buffer
+const (
+	nameQueryHandle       = 0x00
+	nameQueryClass        = 0x08
+	nameQueryBuffer       = 0x10
+	nameQueryLength       = 0x18
+	nameQueryReturnLength = 0x20
+	nameQueryRoutine      = 0x28
+	nameQueryReturnSlot   = 0x30 // receives ReturnLength
+	nameQueryResult       = 0x40
+	nameQueryRegionSize   = 0x11000 // a UNICODE_STRING holds at most 64KB of text
+)
+
+// buildNameQueryStub returns x64 code that calls routine(p[0], p[1], p[2], p[3], p[4]) for the
+// parameter block p passed as the thread argument and returns its NTSTATUS as the exit code
+func buildNameQueryStub() []byte {
+	var stub []byte
+	stub = append(stub, 0x48, 0x83, 0xEC, 0x38)       // sub rsp, 0x38
+	stub = append(stub, 0x48, 0x89, 0xC8)             // mov rax, rcx
+	stub = append(stub, 0x48, 0x8B, 0x08)             // mov rcx, [rax] (Handle)
+	stub = append(stub, 0x48, 0x8B, 0x50, 0x08)       // mov rdx, [rax+0x08] (ObjectInformationClass)
+	stub = append(stub, 0x4C, 0x8B, 0x40, 0x10)       // mov r8, [rax+0x10] (ObjectInformation)
+	stub = append(stub, 0x4C, 0x8B, 0x48, 0x18)       // mov r9, [rax+0x18] (ObjectInformationLength)
+	stub = append(stub, 0x4C, 0x8B, 0x50, 0x20)       // mov r10, [rax+0x20]
+	stub = append(stub, 0x4C, 0x89, 0x54, 0x24, 0x20) // mov [rsp+0x20], r10 (ReturnLength)
+	stub = append(stub, 0xFF, 0x50, 0x28)             // call [rax+0x28]
+	stub = append(stub, 0x48, 0x83, 0xC4, 0x38)       // add rsp, 0x38
+	stub = append(stub, 0xC3)                         // ret
+	return stub
+}
+
+// prepare allocates the stub and the query region on first use
+func (r *handleNameResolver) prepare() error {
+	if r.stub == 0 {
+		ntdll := syscallresolve.GetModuleBase(obf.GetHash("ntdll.dll"))
+		r.routine = syscallresolve.GetFunctionAddress(ntdll, obf.GetHash("NtQueryObject"))
+		if r.routine == 0 {
+			return fmt.Errorf("failed to resolve ntdll!NtQueryObject")
+		}
+
+		stub := buildNameQueryStub()
+		size := uintptr(0x1000)
+		status, err := NtAllocateVirtualMemory(CURRENT_PROCESS, &r.stub, 0, &size, MEM_COMMIT|MEM_RESERVE, PAGE_READWRITE)
+		if err != nil || status != STATUS_SUCCESS {
+			r.stub = 0
+			return fmt.Errorf("NtAllocateVirtualMemory failed: %v (%s)", err, FormatNTStatus(status))
+		}
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(r.stub)), len(stub)), stub)
+		if err := protectLocal(r.stub, size, PAGE_EXECUTE_READ); err != nil {
+			freeLocal(r.stub)
+			r.stub = 0
+			return err
+		}
+		flushInstructionCache(CURRENT_PROCESS, r.stub, size)
+	}
+
+	if r.region == 0 {
+		size := uintptr(nameQueryRegionSize)
+		status, err := NtAllocateVirtualMemory(CURRENT_PROCESS, &r.region, 0, &size, MEM_COMMIT|MEM_RESERVE, PAGE_READWRITE)
+		if err != nil || status != STATUS_SUCCESS {
+			r.region = 0
+			return fmt.Errorf("NtAllocateVirtualMemory failed: %v (%s)", err, FormatNTStatus(status))
+		}
+	}
+	return nil
+}
+
+func (r *handleNameResolver) name(entry HandleEntry) string {
+	if entry.TypeName == "File" && r.hung >= maxHungNameQueries {
+		return ""
+	}
+
+	owner, cached := r.processes[entry.PID]
+	if !cached {
+		owner, _ = openTargetProcess(entry.PID, PROCESS_DUP_HANDLE)
+		r.processes[entry.PID] = owner
+	}
+	if owner == 0 {
+		return ""
+	}
+	if err := r.prepare(); err != nil {
+		debug.Printfln("HANDLES", "Name resolution unavailable: %v\n", err)
+		return ""
+	}
+
+	var duplicate uintptr
+	status, err := NtDuplicateObject(owner, entry.Handle, CURRENT_PROCESS, &duplicate, 0, false, DUPLICATE_SAME_ACCESS)
+	if err != nil || status != STATUS_SUCCESS {
+		return ""
+	}
+
+	params := unsafe.Slice((*uintptr)(unsafe.Pointer(r.region)), nameQueryResult/8)
+	params[nameQueryHandle/8] = duplicate
+	params[nameQueryClass/8] = ObjectNameInformation
+	params[nameQueryBuffer/8] = r.region + nameQueryResult
+	params[nameQueryLength/8] = nameQueryRegionSize - nameQueryResult
+	params[nameQueryReturnLength/8] = r.region + nameQueryReturnSlot
+	params[nameQueryRoutine/8] = r.routine
+
+	thread, err := CreateThread(CURRENT_PROCESS, r.stub, ThreadOptions{Argument: r.region, SkipThreadAttach: true})
+	if err != nil {
+		NtClose(duplicate)
+		return ""
+	}
+	defer NtClose(thread.Handle)
+
+	waitStatus, err := NtWaitForSingleObject(thread.Handle, false, relativeTimeout(r.timeout))
+	if err != nil || waitStatus != WAIT_OBJECT_0 {
+		debug.Printfln("HANDLES", "Name query for handle 0x%X of PID %d timed out (%s)\n", entry.Handle, entry.PID, entry.TypeName)
+		NtTerminateThread(thread.Handle, STATUS_TIMEOUT)
+		if waitStatus, err = NtWaitForSingleObject(thread.Handle, false, relativeTimeout(r.timeout)); err != nil || waitStatus != WAIT_OBJECT_0 {
+			// The thread may still write to the region and use the duplicate: leave both to it
+			r.hung++
+			r.region = 0
+			return ""
+		}
+		NtClose(duplicate)
+		return ""
+	}
+	NtClose(duplicate)
+
+	if exitStatus, err := threadExitStatus(thread.Handle); err != nil || exitStatus != STATUS_SUCCESS {
+		return ""
+	}
+	objectName := (*UNICODE_STRING)(unsafe.Pointer(r.region + nameQueryResult))
+	if objectName.Buffer == nil || objectName.Length == 0 {
+		return ""
+	}
+	return utf16ToString(objectName.Buffer, int(objectName.Length/2))
+}
+
+func (r *handleNameResolver) close() {
+	for _, handle := range r.processes {
+		if handle != 0 {
+			NtClose(handle)
+		}
+	}
+	if r.region != 0 {
+		freeLocal(r.region)
+	}
+	// Threads that could not be terminated may still return into the stub
+	if r.stub != 0 && r.hung == 0 {
+		freeLocal(r.stub)
+	}
+}
+
+// freeLocal releases an allocation of the current process
+func freeLocal(base uintptr) {
+	size := uintptr(0)
+	NtFreeVirtualMemory(CURRENT_PROCESS, &base, &size, MEM_RELEASE)
+}
@@ -0,0 +1,279 @@
+// Package winapi - Thread Context Module
+// Provides aligned CONTEXT allocation and thread execution redirection via Nt{Get,Set}ContextThread
+package winapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// NewContext returns a 16-byte aligned CONTEXT with ContextFlags set to flags. The backing
+// memory is a Go allocation, so the pointer stays valid for as long as it is referenced.
+func NewContext(flags uint32) *CONTEXT {
+	buffer := make([]byte, unsafe.Sizeof(CONTEXT{})+16)
+	base := uintptr(unsafe.Pointer(&buffer[0]))
+	offset := alignUp(base, 16) - base
+
+	ctx := (*CONTEXT)(unsafe.Pointer(&buffer[offset]))
+	ctx.ContextFlags = flags
+	return ctx
+}
+
+// HijackMode selects how the hijacked thread gets back to what it was doing
+type HijackMode int
+
+const (
+	// HijackReturn pushes the interrupted RIP onto the thread's stack and points RIP at the
+	// payload. The payload must preserve every register it touches and end with RET; the stack
+	// alignment at entry is whatever the thread had when it was suspended.
+	HijackReturn HijackMode = iota
+	// HijackRestore runs the payload as a normal function call on a fresh, ABI-aligned frame
+	// below the current stack, with a return address pointing at a spin stub. Once the thread
+	// reaches the stub its original CONTEXT is restored, so the payload may clobber registers
+	// freely but must return.
+	HijackRestore
+)
+
+// HijackOptions configures HijackThread
+type HijackOptions struct {
+	Mode HijackMode
+	// Argument is passed in RCX (HijackRestore only)
+	Argument uintptr
+	// Timeout bounds how long HijackRestore waits for the payload to return; 0 means 30 seconds.
+	// On timeout the thread is left running the payload.
+	Timeout time.Duration
+}
+
+const (
+	hijackPollInterval   = 20 * time.Millisecond
+	hijackDefaultTimeout = 30 * time.Second
+	// hijackStackGap skips over anything the interrupted code may have below RSP
+	hijackStackGap = 0x1000
+)
+
+// HijackThread redirects an existing thread of pid to payloadAddr. With tid 0 the first thread
+// of the process that can be opened is used. payloadAddr must already be executable in the target.
+func HijackThread(pid uint32, tid uintptr, payloadAddr uintptr, opts HijackOptions) error {
+	if payloadAddr == 0 {
+		return fmt.Errorf("invalid payload address")
+	}
+
+	var processHandle uintptr
+	clientId := CLIENT_ID{UniqueProcess: uintptr(pid)}
+	objAttr := OBJECT_ATTRIBUTES{Length: uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{}))}
+	status, err := NtOpenProcess(&processHandle, PROCESS_VM_OPERATION|PROCESS_VM_WRITE|PROCESS_VM_READ|PROCESS_QUERY_INFORMATION, uintptr(unsafe.Pointer(&objAttr)), uintptr(unsafe.Pointer(&clientId)))
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtOpenProcess failed for PID %d: %v (%s)", pid, err, FormatNTStatus(status))
+	}
+	defer NtClose(processHandle)
+
+	return hijackWithHandle(pid, processHandle, tid, payloadAddr, opts)
+}
+
+func hijackWithHandle(pid uint32, processHandle uintptr, tid uintptr, payloadAddr uintptr, opts HijackOptions) error {
+	threadHandle, tid, err := openHijackThread(pid, tid)
+	if err != nil {
+		return err
+	}
+	defer NtClose(threadHandle)
+
+	var suspendCount uintptr
+	status, err := NtSuspendThread(threadHandle, &suspendCount)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtSuspendThread failed for TID %d: %v (%s)", tid, err, FormatNTStatus(status))
+	}
+	resumed := false
+	defer func() {
+		if !resumed {
+			NtResumeThread(threadHandle, &suspendCount)
+		}
+	}()
+
+	original := NewContext(CONTEXT_FULL)
+	status, err = NtGetContextThread(threadHandle, original)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtGetContextThread failed for TID %d: %v (%s)", tid, err, FormatNTStatus(status))
+	}
+	debug.Printfln("HIJACK", "TID %d suspended at RIP 0x%X RSP 0x%X\n", tid, original.Rip, original.Rsp)
+
+	switch opts.Mode {
+	case HijackReturn:
+		return hijackReturn(processHandle, threadHandle, tid, original, payloadAddr, &resumed)
+	case HijackRestore:
+		return hijackRestore(processHandle, threadHandle, tid, original, payloadAddr, opts, &resumed)
+	default:
+		return fmt.Errorf("unknown hijack mode %d", opts.Mode)
+	}
+}
+
+// openHijackThread opens tid, or the first openable thread of pid when tid is 0
+func openHijackThread(pid uint32, tid uintptr) (uintptr, uintptr, error) {
+	access := uintptr(THREAD_SUSPEND_RESUME | THREAD_GET_CONTEXT | THREAD_SET_CONTEXT | THREAD_QUERY_LIMITED_INFORMATION)
+
+	open := func(id uintptr) (uintptr, error) {
+		var threadHandle uintptr
+		clientId := CLIENT_ID{UniqueThread: id}
+		objAttr := OBJECT_ATTRIBUTES{Length: uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{}))}
+		status, err := NtOpenThread(&threadHandle, access, uintptr(unsafe.Pointer(&objAttr)), uintptr(unsafe.Pointer(&clientId)))
+		if err != nil || status != STATUS_SUCCESS {
+			return 0, fmt.Errorf("NtOpenThread failed for TID %d: %v (%s)", id, err, FormatNTStatus(status))
+		}
+		return threadHandle, nil
+	}
+
+	if tid != 0 {
+		handle, err := open(tid)
+		return handle, tid, err
+	}
+
+	threads, err := processThreads(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	var lastErr error
+	for _, thread := range threads {
+		handle, err := open(thread.ClientId.UniqueThread)
+		if err == nil {
+			return handle, thread.ClientId.UniqueThread, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("process %d has no threads", pid)
+	}
+	return 0, 0, lastErr
+}
+
+func writeRemoteUint64(processHandle uintptr, address uintptr, value uint64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], value)
+
+	var written uintptr
+	status, err := NtWriteVirtualMemory(processHandle, address, unsafe.Pointer(&buf[0]), 8, &written)
+	if err != nil || status != STATUS_SUCCESS || written != 8 {
+		return fmt.Errorf("NtWriteVirtualMemory failed at 0x%X: %v (%s)", address, err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+func hijackReturn(processHandle uintptr, threadHandle uintptr, tid uintptr, original *CONTEXT, payloadAddr uintptr, resumed *bool) error {
+	ctx := NewContext(CONTEXT_CONTROL)
+	*ctx = *original
+	ctx.ContextFlags = CONTEXT_CONTROL
+
+	ctx.Rsp -= 8
+	if err := writeRemoteUint64(processHandle, uintptr(ctx.Rsp), original.Rip); err != nil {
+		return err
+	}
+	ctx.Rip = uint64(payloadAddr)
+
+	status, err := NtSetContextThread(threadHandle, ctx)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtSetContextThread failed for TID %d: %v (%s)", tid, err, FormatNTStatus(status))
+	}
+
+	var suspendCount uintptr
+	NtResumeThread(threadHandle, &suspendCount)
+	*resumed = true
+
+	debug.Printfln("HIJACK", "TID %d redirected to 0x%X (returns to 0x%X)\n", tid, payloadAddr, original.Rip)
+	return nil
+}
+
+func hijackRestore(processHandle uintptr, threadHandle uintptr, tid uintptr, original *CONTEXT, payloadAddr uintptr, opts HijackOptions, resumed *bool) error {
+	// Spin stub the payload returns into: jmp $
+	stub, err := injectAllocate(processHandle, 0x1000, PAGE_READWRITE, InjectOptions{})
+	if err != nil {
+		return err
+	}
+	freeStub := func() {
+		base := stub
+		freeSize := uintptr(0)
+		NtFreeVirtualMemory(processHandle, &base, &freeSize, MEM_RELEASE)
+	}
+
+	spin := []byte{0xEB, 0xFE}
+	var written uintptr
+	status, err := NtWriteVirtualMemory(processHandle, stub, unsafe.Pointer(&spin[0]), uintptr(len(spin)), &written)
+	if err != nil || status != STATUS_SUCCESS {
+		freeStub()
+		return fmt.Errorf("failed to write return stub: %v (%s)", err, FormatNTStatus(status))
+	}
+	base := stub
+	protectSize := uintptr(0x1000)
+	var oldProtect uintptr
+	status, err = NtProtectVirtualMemory(processHandle, &base, &protectSize, PAGE_EXECUTE_READ, &oldProtect)
+	if err != nil || status != STATUS_SUCCESS {
+		freeStub()
+		return fmt.Errorf("failed to protect return stub: %v (%s)", err, FormatNTStatus(status))
+	}
+	flushInstructionCache(processHandle, stub, uintptr(len(spin)))
+
+	// Build a call frame: 16-byte aligned before the call, so RSP % 16 == 8 at entry once the
+	// return address is pushed, with 32 bytes of shadow space above the return address
+	frame := alignDown(uintptr(original.Rsp)-hijackStackGap, 16)
+	returnSlot := frame - 0x20 - 8
+	if err := writeRemoteUint64(processHandle, returnSlot, uint64(stub)); err != nil {
+		freeStub()
+		return err
+	}
+
+	ctx := NewContext(CONTEXT_FULL)
+	*ctx = *original
+	ctx.ContextFlags = CONTEXT_FULL
+	ctx.Rsp = uint64(returnSlot)
+	ctx.Rip = uint64(payloadAddr)
+	ctx.Rcx = uint64(opts.Argument)
+
+	status, err = NtSetContextThread(threadHandle, ctx)
+	if err != nil || status != STATUS_SUCCESS {
+		freeStub()
+		return fmt.Errorf("NtSetContextThread failed for TID %d: %v (%s)", tid, err, FormatNTStatus(status))
+	}
+
+	var suspendCount uintptr
+	NtResumeThread(threadHandle, &suspendCount)
+	*resumed = true
+	debug.Printfln("HIJACK", "TID %d running payload at 0x%X, waiting for return to 0x%X\n", tid, payloadAddr, stub)
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = hijackDefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	probe := NewContext(CONTEXT_CONTROL)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(hijackPollInterval)
+
+		status, err = NtSuspendThread(threadHandle, &suspendCount)
+		if err != nil || status != STATUS_SUCCESS {
+			// The thread is gone; nothing left to restore
+			freeStub()
+			return fmt.Errorf("TID %d could not be suspended while waiting: %v (%s)", tid, err, FormatNTStatus(status))
+		}
+
+		probe.ContextFlags = CONTEXT_CONTROL
+		status, err = NtGetContextThread(threadHandle, probe)
+		if err == nil && status == STATUS_SUCCESS && uintptr(probe.Rip) == stub {
+			status, err = NtSetContextThread(threadHandle, original)
+			NtResumeThread(threadHandle, &suspendCount)
+			if err != nil || status != STATUS_SUCCESS {
+				// Leave the stub mapped: the thread is still spinning in it
+				return fmt.Errorf("failed to restore context of TID %d: %v (%s)", tid, err, FormatNTStatus(status))
+			}
+			freeStub()
+			debug.Printfln("HIJACK", "TID %d restored to RIP 0x%X\n", tid, original.Rip)
+			return nil
+		}
+
+		NtResumeThread(threadHandle, &suspendCount)
+	}
+
+	// The payload is still running; the stub must stay mapped for it to return into
+	return fmt.Errorf("TID %d did not return from payload within %v", tid, timeout)
+}
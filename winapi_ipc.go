@@ -0,0 +1,381 @@
+// Package winapi - Shared Memory IPC Module
+// Provides a small message channel built on named sections and events, using Nt* calls only
+package winapi
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+// Shared view layout:
+//
+//	+0  uint32 capacity of each slot (written by the creator, read by the opener)
+//	+8  slot 0, creator to opener
+//	+8+channelSlotHeaderSize+capacity  slot 1, opener to creator
+//
+// Each slot is a uint32 message length, 4 bytes of padding and capacity message bytes.
+const (
+	channelHeaderSize     = 8
+	channelSlotHeaderSize = 8
+	channelDefaultSize    = 0x10000
+	channelDataReadySfx   = "_rd"
+	channelSpaceFreeSfx   = "_wr"
+)
+
+// Channel is a length-prefixed message channel shared between two processes (typically an
+// injector and its injected payload). One side creates it, the other opens it by name. Each
+// direction has its own slot, so both sides may Send and Recv and a request/response exchange
+// never reads back its own message; at most one message per direction is in flight.
+type Channel struct {
+	Name string
+	// Timeout bounds how long Send and Recv wait; zero waits forever
+	Timeout time.Duration
+
+	section uintptr
+	// slots[0] carries creator to opener messages, slots[1] opener to creator
+	slots [2]channelSlot
+	// outgoing is the index of the slot this side sends on
+	outgoing int
+	view     uintptr
+	viewSize uintptr
+	capacity uint32
+}
+
+// channelSlot is one direction of a Channel
+type channelSlot struct {
+	offset    uintptr // of the slot header in the view
+	dataReady uintptr
+	spaceFree uintptr
+}
+
+// channelSlotOffset returns the view offset of slot direction (0 or 1) for capacity
+func channelSlotOffset(direction int, capacity uint32) uintptr {
+	return channelHeaderSize + uintptr(direction)*(channelSlotHeaderSize+uintptr(capacity))
+}
+
+// channelObjectName resolves a channel name to its object manager path. Names that already
+// start with a backslash are used as-is. "Global\" names go in \BaseNamedObjects, which
+// creating from outside session 0 needs SeCreateGlobalPrivilege for. Anything else, with or
+// without "Local\", goes in the caller's session, so it only reaches a peer in the same
+// session: a payload inside a session-0 service needs a Global or absolute name.
+func channelObjectName(name string) string {
+	if strings.HasPrefix(name, "\\") {
+		return name
+	}
+	if rest, ok := cutPrefixFold(name, "Global\\"); ok {
+		return "\\BaseNamedObjects\\" + rest
+	}
+	name, _ = cutPrefixFold(name, "Local\\")
+
+	sessionID := uint32(0)
+	if peb := syscallresolve.GetCurrentProcessPEB(); peb != nil {
+		sessionID = peb.SessionId
+	}
+	if sessionID == 0 {
+		return "\\BaseNamedObjects\\" + name
+	}
+	return fmt.Sprintf("\\Sessions\\%d\\BaseNamedObjects\\%s", sessionID, name)
+}
+
+// cutPrefixFold is strings.CutPrefix with a case-insensitive prefix
+func cutPrefixFold(s string, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// relativeTimeout converts a duration into an NT relative timeout, or nil for an infinite wait
+func relativeTimeout(d time.Duration) *uint64 {
+	if d <= 0 {
+		return nil
+	}
+	timeout := uint64(-int64(d / 100))
+	return &timeout
+}
+
+// CreateChannel creates a named channel able to carry messages up to capacity bytes in each
+// direction. A capacity of 0 selects 64KB slots.
+func CreateChannel(name string, capacity uint32) (*Channel, error) {
+	if name == "" {
+		return nil, fmt.Errorf("channel name must not be empty")
+	}
+	if capacity == 0 {
+		capacity = channelDefaultSize - channelSlotHeaderSize
+	}
+
+	objectName := channelObjectName(name)
+	ch := &Channel{Name: name, capacity: capacity}
+
+	sectionName := NewUnicodeString(StringToUTF16(objectName))
+	objAttr := OBJECT_ATTRIBUTES{
+		Length:     uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{})),
+		ObjectName: &sectionName,
+		Attributes: OBJ_CASE_INSENSITIVE,
+	}
+
+	maxSize := uint64(channelSlotOffset(2, capacity)) // end of slot 1
+	status, err := NtCreateSection(
+		&ch.section,
+		SECTION_ALL_ACCESS,
+		uintptr(unsafe.Pointer(&objAttr)),
+		&maxSize,
+		PAGE_READWRITE,
+		SEC_COMMIT,
+		0,
+	)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("NtCreateSection failed for %s: %v (%s)", objectName, err, FormatNTStatus(status))
+	}
+
+	if err := ch.createEvents(objectName); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	if err := ch.mapView(); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	*(*uint32)(unsafe.Pointer(ch.view)) = capacity
+	ch.useSlots(0)
+
+	debug.Printfln("IPC", "Created channel %s (capacity %d)\n", objectName, capacity)
+	return ch, nil
+}
+
+// OpenChannel opens a channel previously created with CreateChannel
+func OpenChannel(name string) (*Channel, error) {
+	if name == "" {
+		return nil, fmt.Errorf("channel name must not be empty")
+	}
+
+	objectName := channelObjectName(name)
+	ch := &Channel{Name: name}
+
+	sectionName := NewUnicodeString(StringToUTF16(objectName))
+	objAttr := OBJECT_ATTRIBUTES{
+		Length:     uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{})),
+		ObjectName: &sectionName,
+		Attributes: OBJ_CASE_INSENSITIVE,
+	}
+
+	status, err := NtOpenSection(&ch.section, SECTION_MAP_READ|SECTION_MAP_WRITE|SECTION_QUERY, uintptr(unsafe.Pointer(&objAttr)))
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("NtOpenSection failed for %s: %v (%s)", objectName, err, FormatNTStatus(status))
+	}
+
+	if err := ch.openEvents(objectName); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	if err := ch.mapView(); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	ch.capacity = *(*uint32)(unsafe.Pointer(ch.view))
+	if uint64(ch.capacity) > uint64(ch.viewSize) || channelSlotOffset(2, ch.capacity) > ch.viewSize {
+		ch.Close()
+		return nil, fmt.Errorf("channel %s has a corrupt header (capacity %d, view 0x%X)", objectName, ch.capacity, ch.viewSize)
+	}
+	ch.useSlots(1)
+
+	debug.Printfln("IPC", "Opened channel %s (capacity %d)\n", objectName, ch.capacity)
+	return ch, nil
+}
+
+// useSlots records which slot this side sends on once the capacity is known and clears the
+// outgoing slot
+func (ch *Channel) useSlots(outgoing int) {
+	ch.outgoing = outgoing
+	for i := range ch.slots {
+		ch.slots[i].offset = channelSlotOffset(i, ch.capacity)
+	}
+	*(*uint32)(unsafe.Pointer(ch.view + ch.send().offset)) = 0
+}
+
+func (ch *Channel) send() *channelSlot { return &ch.slots[ch.outgoing] }
+func (ch *Channel) recv() *channelSlot { return &ch.slots[1-ch.outgoing] }
+
+// channelEvent is a named event of a Channel
+type channelEvent struct {
+	handle *uintptr
+	suffix string
+}
+
+// eventHandles lists the event handles of both slots together with their name suffixes
+func (ch *Channel) eventHandles() []channelEvent {
+	var events []channelEvent
+	for i := range ch.slots {
+		events = append(events,
+			channelEvent{&ch.slots[i].dataReady, fmt.Sprintf("%s%d", channelDataReadySfx, i)},
+			channelEvent{&ch.slots[i].spaceFree, fmt.Sprintf("%s%d", channelSpaceFreeSfx, i)},
+		)
+	}
+	return events
+}
+
+func (ch *Channel) createEvents(objectName string) error {
+	for _, ev := range ch.eventHandles() {
+		// dataReady starts clear (nothing to read), spaceFree starts set (slot is empty)
+		initial := strings.HasPrefix(ev.suffix, channelSpaceFreeSfx)
+		eventName := NewUnicodeString(StringToUTF16(objectName + ev.suffix))
+		objAttr := OBJECT_ATTRIBUTES{
+			Length:     uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{})),
+			ObjectName: &eventName,
+			Attributes: OBJ_CASE_INSENSITIVE,
+		}
+
+		status, err := NtCreateEvent(ev.handle, EVENT_ALL_ACCESS, uintptr(unsafe.Pointer(&objAttr)), SynchronizationEvent, initial)
+		if err != nil || status != STATUS_SUCCESS {
+			return fmt.Errorf("NtCreateEvent failed for %s: %v (%s)", objectName+ev.suffix, err, FormatNTStatus(status))
+		}
+	}
+
+	return nil
+}
+
+func (ch *Channel) openEvents(objectName string) error {
+	for _, ev := range ch.eventHandles() {
+		eventName := NewUnicodeString(StringToUTF16(objectName + ev.suffix))
+		objAttr := OBJECT_ATTRIBUTES{
+			Length:     uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{})),
+			ObjectName: &eventName,
+			Attributes: OBJ_CASE_INSENSITIVE,
+		}
+
+		status, err := NtOpenEvent(ev.handle, EVENT_MODIFY_STATE|SYNCHRONIZE, uintptr(unsafe.Pointer(&objAttr)))
+		if err != nil || status != STATUS_SUCCESS {
+			return fmt.Errorf("NtOpenEvent failed for %s: %v (%s)", objectName+ev.suffix, err, FormatNTStatus(status))
+		}
+	}
+
+	return nil
+}
+
+func (ch *Channel) mapView() error {
+	var sectionOffset uint64
+	status, err := NtMapViewOfSection(
+		ch.section,
+		CURRENT_PROCESS,
+		&ch.view,
+		0,
+		0,
+		&sectionOffset,
+		&ch.viewSize,
+		ViewUnmap,
+		0,
+		PAGE_READWRITE,
+	)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtMapViewOfSection failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+func (ch *Channel) wait(handle uintptr) error {
+	status, err := NtWaitForSingleObject(handle, false, relativeTimeout(ch.Timeout))
+	if err != nil {
+		return fmt.Errorf("NtWaitForSingleObject error: %v", err)
+	}
+	switch status {
+	case WAIT_OBJECT_0:
+		return nil
+	case WAIT_TIMEOUT:
+		return fmt.Errorf("channel %s: timed out after %v", ch.Name, ch.Timeout)
+	default:
+		return fmt.Errorf("NtWaitForSingleObject failed: %s", FormatNTStatus(status))
+	}
+}
+
+// Capacity returns the largest message the channel can carry
+func (ch *Channel) Capacity() uint32 {
+	return ch.capacity
+}
+
+// Send waits for this side's outgoing slot to be free, copies data into it and signals the peer
+func (ch *Channel) Send(data []byte) error {
+	if ch.view == 0 {
+		return fmt.Errorf("channel is closed")
+	}
+	if uint64(len(data)) > uint64(ch.capacity) {
+		return fmt.Errorf("message of %d bytes exceeds channel capacity of %d", len(data), ch.capacity)
+	}
+
+	if err := ch.wait(ch.send().spaceFree); err != nil {
+		return err
+	}
+
+	slot := ch.view + ch.send().offset
+	if len(data) > 0 {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(slot+channelSlotHeaderSize)), len(data)), data)
+	}
+	*(*uint32)(unsafe.Pointer(slot)) = uint32(len(data))
+
+	status, err := NtSetEvent(ch.send().dataReady, nil)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtSetEvent failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// Recv waits for a message from the peer, copies it out of the incoming slot and frees the slot
+// for the peer
+func (ch *Channel) Recv() ([]byte, error) {
+	if ch.view == 0 {
+		return nil, fmt.Errorf("channel is closed")
+	}
+
+	if err := ch.wait(ch.recv().dataReady); err != nil {
+		return nil, err
+	}
+
+	slot := ch.view + ch.recv().offset
+	length := *(*uint32)(unsafe.Pointer(slot))
+	if length > ch.capacity {
+		// Leave the slot usable even if the peer wrote garbage
+		NtSetEvent(ch.recv().spaceFree, nil)
+		return nil, fmt.Errorf("received length %d exceeds channel capacity of %d", length, ch.capacity)
+	}
+
+	data := make([]byte, length)
+	if length > 0 {
+		copy(data, unsafe.Slice((*byte)(unsafe.Pointer(slot+channelSlotHeaderSize)), length))
+		// Don't leave the message readable in the shared view once it has been consumed
+		WipeMemory(slot+channelSlotHeaderSize, uintptr(length))
+	}
+	*(*uint32)(unsafe.Pointer(slot)) = 0
+
+	status, err := NtSetEvent(ch.recv().spaceFree, nil)
+	if err != nil || status != STATUS_SUCCESS {
+		return data, fmt.Errorf("NtSetEvent failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return data, nil
+}
+
+// Close unmaps the view and closes every handle owned by the channel
+func (ch *Channel) Close() error {
+	if ch.view != 0 {
+		NtUnmapViewOfSection(CURRENT_PROCESS, ch.view)
+		ch.view = 0
+	}
+	handles := []*uintptr{&ch.section}
+	for _, ev := range ch.eventHandles() {
+		handles = append(handles, ev.handle)
+	}
+	for _, handle := range handles {
+		if *handle != 0 {
+			NtClose(*handle)
+			*handle = 0
+		}
+	}
+	return nil
+}
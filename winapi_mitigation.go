@@ -0,0 +1,107 @@
+// Package winapi - Process Mitigation Module
+// Provides querying and applying process mitigation policies via Nt{Query,Set}InformationProcess
+package winapi
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// GetMitigationPolicy returns the flags of policy (one of the Process*Policy values) for
+// processHandle, which needs PROCESS_QUERY_INFORMATION or PROCESS_QUERY_LIMITED_INFORMATION
+func GetMitigationPolicy(processHandle uintptr, policy uint32) (uint32, error) {
+	info := PROCESS_MITIGATION_POLICY_INFORMATION{Policy: policy}
+	var returnLength uintptr
+	status, err := NtQueryInformationProcess(processHandle, ProcessMitigationPolicy, unsafe.Pointer(&info), unsafe.Sizeof(info), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtQueryInformationProcess(ProcessMitigationPolicy %d) failed: %v (%s)", policy, err, FormatNTStatus(status))
+	}
+	return info.Flags, nil
+}
+
+// SetMitigationPolicy enables flags of policy on the current process. Mitigations can only
+// be turned on from inside the process, and most cannot be turned off again once set.
+func SetMitigationPolicy(policy uint32, flags uint32) error {
+	info := PROCESS_MITIGATION_POLICY_INFORMATION{Policy: policy, Flags: flags}
+	status, err := NtSetInformationProcess(CURRENT_PROCESS, ProcessMitigationPolicy, unsafe.Pointer(&info), unsafe.Sizeof(info))
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtSetInformationProcess(ProcessMitigationPolicy %d) failed: %v (%s)", policy, err, FormatNTStatus(status))
+	}
+	debug.Printfln("WINAPI", "Mitigation policy %d set to 0x%X\n", policy, flags)
+	return nil
+}
+
+// HardeningOptions selects the mitigations HardenCurrentProcess applies
+type HardeningOptions struct {
+	// MicrosoftSignedOnly refuses to load images not signed by Microsoft from now on
+	MicrosoftSignedOnly bool
+	// ProhibitDynamicCode blocks new executable memory and RW->X changes. This also stops the
+	// library's own executable allocations (self-injection, ExecuteLocal, dynamic stubs).
+	ProhibitDynamicCode bool
+	// NoRemoteImages blocks images loaded from remote (UNC) paths
+	NoRemoteImages bool
+	// NoLowLabelImages blocks images carrying the low mandatory label
+	NoLowLabelImages bool
+	// PreferSystem32Images searches System32 before the application directory
+	PreferSystem32Images bool
+	// DisableExtensionPoints stops legacy extension DLLs (AppInit_DLLs, IMEs, ...) from loading
+	DisableExtensionPoints bool
+	// StrictHandleChecks raises an exception on use of an invalid handle
+	StrictHandleChecks bool
+	// NoChildProcesses prevents the process from creating child processes
+	NoChildProcesses bool
+}
+
+// HardenCurrentProcess applies the mitigations selected in opts to the current process. Every
+// selected policy is attempted; the returned error lists the ones that failed.
+func HardenCurrentProcess(opts HardeningOptions) error {
+	type request struct {
+		name   string
+		policy uint32
+		flags  uint32
+	}
+	var requests []request
+
+	if opts.MicrosoftSignedOnly {
+		requests = append(requests, request{"signature", ProcessSignaturePolicy, MITIGATION_SIGNATURE_MICROSOFT_SIGNED_ONLY})
+	}
+	if opts.ProhibitDynamicCode {
+		requests = append(requests, request{"dynamic code", ProcessDynamicCodePolicy, MITIGATION_DYNAMIC_CODE_PROHIBIT})
+	}
+	var imageLoad uint32
+	if opts.NoRemoteImages {
+		imageLoad |= MITIGATION_IMAGE_LOAD_NO_REMOTE
+	}
+	if opts.NoLowLabelImages {
+		imageLoad |= MITIGATION_IMAGE_LOAD_NO_LOW_LABEL
+	}
+	if opts.PreferSystem32Images {
+		imageLoad |= MITIGATION_IMAGE_LOAD_PREFER_SYSTEM32
+	}
+	if imageLoad != 0 {
+		requests = append(requests, request{"image load", ProcessImageLoadPolicy, imageLoad})
+	}
+	if opts.DisableExtensionPoints {
+		requests = append(requests, request{"extension point", ProcessExtensionPointDisablePolicy, MITIGATION_EXTENSION_POINT_DISABLE})
+	}
+	if opts.StrictHandleChecks {
+		requests = append(requests, request{"strict handle", ProcessStrictHandleCheckPolicy, MITIGATION_STRICT_HANDLE_RAISE_ON_INVALID | MITIGATION_STRICT_HANDLE_PERMANENT})
+	}
+	if opts.NoChildProcesses {
+		requests = append(requests, request{"child process", ProcessChildProcessPolicy, MITIGATION_CHILD_PROCESS_NO_CREATION})
+	}
+
+	var failed []string
+	for _, r := range requests {
+		if err := SetMitigationPolicy(r.policy, r.flags); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d mitigation policies failed: %s", len(failed), len(requests), strings.Join(failed, "; "))
+	}
+	return nil
+}
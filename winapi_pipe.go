@@ -0,0 +1,278 @@
+// Package winapi - Named Pipe Module
+// Provides a named-pipe server with client impersonation built on NtCreateNamedPipeFile
+package winapi
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+const pipeDefaultBufferSize = 0x1000
+
+// PipeServerOptions configures CreatePipeServer
+type PipeServerOptions struct {
+	// SecurityDescriptor is applied to the pipe; nil uses the default DACL of the caller's
+	// token. Use PermissiveSecurityDescriptor to let clients of any user connect.
+	SecurityDescriptor *SecurityDescriptor
+	// MaxInstances limits the number of pipe instances; 0 means unlimited
+	MaxInstances uint32
+	// BufferSize is the in/out quota and the largest single Read or Write; 0 means 4KB
+	BufferSize uint32
+	// MessageMode creates a message pipe instead of a byte stream
+	MessageMode bool
+}
+
+// PipeServer is one server instance of a named pipe
+type PipeServer struct {
+	// Name is the NT path of the pipe, e.g. \Device\NamedPipe\example
+	Name string
+	// Timeout bounds Accept, Read and Write; zero waits forever
+	Timeout time.Duration
+
+	handle uintptr
+	event  uintptr
+	sd     *SecurityDescriptor
+	// iosb and buffer live on the heap so pending I/O never writes into a moved goroutine stack
+	iosb   IO_STATUS_BLOCK
+	buffer []byte
+}
+
+// pipeObjectName maps "example", \\.\pipe\example and NT paths to an NPFS object name
+func pipeObjectName(name string) string {
+	if rest, ok := strings.CutPrefix(name, `\\.\pipe\`); ok {
+		return `\Device\NamedPipe\` + rest
+	}
+	if strings.HasPrefix(name, `\`) {
+		return name
+	}
+	return `\Device\NamedPipe\` + name
+}
+
+// CreatePipeServer creates a server instance of the named pipe name, which may be a bare name,
+// a \\.\pipe\ path or an NT path
+func CreatePipeServer(name string, opts PipeServerOptions) (*PipeServer, error) {
+	bufferSize := opts.BufferSize
+	if bufferSize == 0 {
+		bufferSize = pipeDefaultBufferSize
+	}
+	maxInstances := uintptr(opts.MaxInstances)
+	if maxInstances == 0 {
+		maxInstances = FILE_PIPE_UNLIMITED_INSTANCES
+	}
+	pipeType, readMode := uintptr(FILE_PIPE_BYTE_STREAM_TYPE), uintptr(FILE_PIPE_BYTE_STREAM_MODE)
+	if opts.MessageMode {
+		pipeType, readMode = FILE_PIPE_MESSAGE_TYPE, FILE_PIPE_MESSAGE_MODE
+	}
+
+	p := &PipeServer{
+		Name:   pipeObjectName(name),
+		sd:     opts.SecurityDescriptor,
+		buffer: make([]byte, bufferSize),
+	}
+
+	status, err := NtCreateEvent(&p.event, EVENT_ALL_ACCESS, 0, SynchronizationEvent, false)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("NtCreateEvent failed: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	pipeName := NewUnicodeString(StringToUTF16(p.Name))
+	objAttr := OBJECT_ATTRIBUTES{
+		Length:     uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{})),
+		ObjectName: &pipeName,
+		Attributes: OBJ_CASE_INSENSITIVE,
+	}
+	if p.sd != nil {
+		objAttr.SecurityDescriptor = p.sd.Pointer()
+	}
+
+	defaultTimeout := relativeTimeout(50 * time.Millisecond)
+	status, err = NtCreateNamedPipeFile(
+		&p.handle,
+		GENERIC_READ|GENERIC_WRITE|SYNCHRONIZE,
+		uintptr(unsafe.Pointer(&objAttr)),
+		uintptr(unsafe.Pointer(&p.iosb)),
+		FILE_SHARE_READ|FILE_SHARE_WRITE,
+		FILE_OPEN_IF,
+		0, // asynchronous handle, completion is signalled through p.event
+		pipeType,
+		readMode,
+		FILE_PIPE_QUEUE_OPERATION,
+		maxInstances,
+		uintptr(bufferSize),
+		uintptr(bufferSize),
+		defaultTimeout,
+	)
+	runtime.KeepAlive(p.sd)
+	if err != nil || status != STATUS_SUCCESS {
+		NtClose(p.event)
+		return nil, fmt.Errorf("NtCreateNamedPipeFile failed for %s: %v (%s)", p.Name, err, FormatNTStatus(status))
+	}
+
+	debug.Printfln("PIPE", "Created pipe server %s\n", p.Name)
+	return p, nil
+}
+
+// complete waits for an operation that returned status to finish and returns its final status.
+// On timeout the operation is cancelled before returning, so the kernel is done with p.iosb and
+// p.buffer. Must run on the OS thread that issued the operation.
+func (p *PipeServer) complete(operation string, status uintptr, err error) (uintptr, error) {
+	if err != nil {
+		return status, fmt.Errorf("%s failed: %v", operation, err)
+	}
+	if status != STATUS_PENDING {
+		return status, nil
+	}
+
+	var timeout *uint64
+	if p.Timeout > 0 {
+		timeout = relativeTimeout(p.Timeout)
+	}
+	waitStatus, err := NtWaitForSingleObject(p.event, false, timeout)
+	if err != nil {
+		return status, fmt.Errorf("NtWaitForSingleObject failed: %v", err)
+	}
+	if waitStatus == WAIT_TIMEOUT {
+		var cancelIosb IO_STATUS_BLOCK
+		NtCancelIoFile(p.handle, uintptr(unsafe.Pointer(&cancelIosb)))
+		NtWaitForSingleObject(p.event, false, nil)
+		return STATUS_TIMEOUT, fmt.Errorf("%s timed out after %v", operation, p.Timeout)
+	}
+	return p.iosb.Status, nil
+}
+
+// control issues an FSCTL on the pipe and waits for it
+func (p *PipeServer) control(name string, code uintptr) (uintptr, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	status, err := NtFsControlFile(p.handle, p.event, 0, 0, uintptr(unsafe.Pointer(&p.iosb)), code, nil, 0, nil, 0)
+	return p.complete(name, status, err)
+}
+
+// Accept waits for a client to connect to this instance
+func (p *PipeServer) Accept() error {
+	status, err := p.control("FSCTL_PIPE_LISTEN", FSCTL_PIPE_LISTEN)
+	if err != nil {
+		return err
+	}
+	// STATUS_PIPE_CONNECTED: the client connected between creation and the listen call
+	if status != STATUS_SUCCESS && status != STATUS_PIPE_CONNECTED {
+		return fmt.Errorf("FSCTL_PIPE_LISTEN failed: %s", FormatNTStatus(status))
+	}
+	debug.Printfln("PIPE", "Client connected to %s\n", p.Name)
+	return nil
+}
+
+// Read reads at most BufferSize bytes from the connected client. In message mode a message
+// larger than b is returned in several reads.
+func (p *PipeServer) Read(b []byte) (int, error) {
+	if len(b) > len(p.buffer) {
+		b = b[:len(p.buffer)]
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	status, err := NtReadFile(p.handle, p.event, 0, 0, uintptr(unsafe.Pointer(&p.iosb)), unsafe.Pointer(&p.buffer[0]), uintptr(len(b)), nil, nil)
+	status, err = p.complete("NtReadFile", status, err)
+	if err != nil {
+		return 0, err
+	}
+	if status != STATUS_SUCCESS && status != STATUS_BUFFER_OVERFLOW {
+		return 0, fmt.Errorf("NtReadFile failed: %s", FormatNTStatus(status))
+	}
+	return copy(b, p.buffer[:p.iosb.Information]), nil
+}
+
+// Write writes b to the connected client; b must not exceed BufferSize
+func (p *PipeServer) Write(b []byte) (int, error) {
+	if len(b) > len(p.buffer) {
+		return 0, fmt.Errorf("write of %d bytes exceeds the pipe buffer size of %d", len(b), len(p.buffer))
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	copy(p.buffer, b)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	status, err := NtWriteFile(p.handle, p.event, 0, 0, uintptr(unsafe.Pointer(&p.iosb)), unsafe.Pointer(&p.buffer[0]), uintptr(len(b)), nil, nil)
+	status, err = p.complete("NtWriteFile", status, err)
+	if err != nil {
+		return 0, err
+	}
+	if status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtWriteFile failed: %s", FormatNTStatus(status))
+	}
+	return int(p.iosb.Information), nil
+}
+
+// ImpersonateClient impersonates the connected client on the current OS thread. The client must
+// have written to the pipe and the server must have read it first. Hold runtime.LockOSThread
+// until RevertToSelf.
+func (p *PipeServer) ImpersonateClient() error {
+	status, err := NtFsControlFile(p.handle, 0, 0, 0, uintptr(unsafe.Pointer(&p.iosb)), FSCTL_PIPE_IMPERSONATE, nil, 0, nil, 0)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("FSCTL_PIPE_IMPERSONATE failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// ClientToken impersonates the connected client, opens the resulting thread token and reverts.
+// The caller closes the returned impersonation token; DuplicateToken with TokenPrimary turns it
+// into a token for CreateProcessWithTokenNative. Any impersonation already active on the calling
+// thread is dropped.
+func (p *PipeServer) ClientToken() (uintptr, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := p.ImpersonateClient(); err != nil {
+		return 0, err
+	}
+	defer RevertToSelf()
+
+	var token uintptr
+	status, err := NtOpenThreadToken(CURRENT_THREAD, TOKEN_ALL_ACCESS, true, &token)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtOpenThreadToken failed: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	if sid, err := tokenUserSID(token); err == nil {
+		debug.Printfln("PIPE", "Captured client token of %s on %s\n", sid, p.Name)
+	}
+	return token, nil
+}
+
+// Disconnect drops the connected client so the instance can Accept another one
+func (p *PipeServer) Disconnect() error {
+	status, err := p.control("FSCTL_PIPE_DISCONNECT", FSCTL_PIPE_DISCONNECT)
+	if err != nil {
+		return err
+	}
+	if status != STATUS_SUCCESS && status != STATUS_PIPE_DISCONNECTED {
+		return fmt.Errorf("FSCTL_PIPE_DISCONNECT failed: %s", FormatNTStatus(status))
+	}
+	return nil
+}
+
+// Close closes the pipe instance and its event
+func (p *PipeServer) Close() error {
+	var err error
+	if p.handle != 0 {
+		if status, closeErr := NtClose(p.handle); closeErr != nil || status != STATUS_SUCCESS {
+			err = fmt.Errorf("NtClose failed for pipe: %v (%s)", closeErr, FormatNTStatus(status))
+		}
+		p.handle = 0
+	}
+	if p.event != 0 {
+		NtClose(p.event)
+		p.event = 0
+	}
+	return err
+}
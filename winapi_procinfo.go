@@ -0,0 +1,321 @@
+// Package winapi - Process Information Module
+// Provides command-line, environment and aggregated process queries for other processes
+package winapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// Offsets into PEB and RTL_USER_PROCESS_PARAMETERS on x64
+const (
+	pebProcessParametersOffset = 0x20
+	paramsCommandLineOffset    = 0x70
+	paramsEnvironmentOffset    = 0x80
+	paramsEnvironmentSize      = 0x3F0 // EnvironmentSize, Windows 10 and later
+	paramsReadSize             = 0x3F8
+	maxRemoteEnvironment       = 0x400000
+)
+
+// GetRemoteCommandLine returns the command line of processHandle. It uses
+// ProcessCommandLineInformation, which only needs PROCESS_QUERY_LIMITED_INFORMATION, and falls
+// back to reading RTL_USER_PROCESS_PARAMETERS, which also needs PROCESS_VM_READ.
+func GetRemoteCommandLine(processHandle uintptr) (string, error) {
+	if commandLine, err := queryProcessString(processHandle, ProcessCommandLineInformation); err == nil {
+		return commandLine, nil
+	}
+
+	params, err := readRemoteProcessParameters(processHandle)
+	if err != nil {
+		return "", err
+	}
+	return readRemoteUnicodeString(processHandle,
+		binary.LittleEndian.Uint16(params[paramsCommandLineOffset:]),
+		uintptr(binary.LittleEndian.Uint64(params[paramsCommandLineOffset+8:]))), nil
+}
+
+// queryProcessString returns a process information class that is answered with a
+// UNICODE_STRING followed by its characters (image file names, the command line)
+func queryProcessString(processHandle uintptr, infoClass uintptr) (string, error) {
+	buffer := make([]byte, 0x1000)
+	for attempt := 0; attempt < 4; attempt++ {
+		var returnLength uintptr
+		status, err := NtQueryInformationProcess(processHandle, infoClass, unsafe.Pointer(&buffer[0]), uintptr(len(buffer)), &returnLength)
+		if err != nil {
+			return "", fmt.Errorf("NtQueryInformationProcess(%d) error: %v", infoClass, err)
+		}
+		if status == STATUS_INFO_LENGTH_MISMATCH && returnLength > uintptr(len(buffer)) {
+			buffer = make([]byte, returnLength)
+			continue
+		}
+		if status != STATUS_SUCCESS {
+			return "", fmt.Errorf("NtQueryInformationProcess(%d) failed: %s", infoClass, FormatNTStatus(status))
+		}
+		// The UNICODE_STRING points into buffer itself
+		value := (*UNICODE_STRING)(unsafe.Pointer(&buffer[0]))
+		if value.Buffer == nil || value.Length == 0 {
+			return "", nil
+		}
+		return utf16ToString(value.Buffer, int(value.Length/2)), nil
+	}
+	return "", fmt.Errorf("NtQueryInformationProcess(%d) kept growing past 0x%X bytes", infoClass, len(buffer))
+}
+
+// GetRemoteEnvironment returns the environment block of processHandle as "NAME=value" entries
+// in the order of the block, like os.Environ. processHandle needs
+// PROCESS_QUERY_LIMITED_INFORMATION and PROCESS_VM_READ. The block is read as it is now, so
+// variables the target changed after start are reflected.
+func GetRemoteEnvironment(processHandle uintptr) ([]string, error) {
+	params, err := readRemoteProcessParameters(processHandle)
+	if err != nil {
+		return nil, err
+	}
+	environment := uintptr(binary.LittleEndian.Uint64(params[paramsEnvironmentOffset:]))
+	if environment == 0 {
+		return nil, fmt.Errorf("process has no environment block")
+	}
+
+	var block []byte
+	if size := uintptr(binary.LittleEndian.Uint64(params[paramsEnvironmentSize:])); size > 0 && size <= maxRemoteEnvironment {
+		block = make([]byte, size)
+		var bytesRead uintptr
+		status, err := NtReadVirtualMemory(processHandle, environment, unsafe.Pointer(&block[0]), size, &bytesRead)
+		if err != nil || status != STATUS_SUCCESS {
+			return nil, fmt.Errorf("failed to read the environment block at 0x%X: %v (%s)", environment, err, FormatNTStatus(status))
+		}
+	} else {
+		// No recorded size: read page by page until the terminating empty string
+		for len(block) < maxRemoteEnvironment {
+			address := environment + uintptr(len(block))
+			chunk := make([]byte, 0x1000-address%0x1000)
+			var bytesRead uintptr
+			status, err := NtReadVirtualMemory(processHandle, address, unsafe.Pointer(&chunk[0]), uintptr(len(chunk)), &bytesRead)
+			if err != nil || status != STATUS_SUCCESS {
+				if len(block) == 0 {
+					return nil, fmt.Errorf("failed to read the environment block at 0x%X: %v (%s)", environment, err, FormatNTStatus(status))
+				}
+				break
+			}
+			block = append(block, chunk...)
+			if environmentEnd(block) >= 0 {
+				break
+			}
+		}
+	}
+
+	return parseEnvironmentBlock(block), nil
+}
+
+// readRemoteProcessParameters reads the head of RTL_USER_PROCESS_PARAMETERS of processHandle
+func readRemoteProcessParameters(processHandle uintptr) ([]byte, error) {
+	peb, err := remotePEBAddress(processHandle)
+	if err != nil {
+		return nil, err
+	}
+	params, err := readRemotePointer(processHandle, peb+pebProcessParametersOffset)
+	if err != nil {
+		return nil, err
+	}
+	if params == 0 {
+		return nil, fmt.Errorf("process parameters not initialized yet")
+	}
+
+	buffer := make([]byte, paramsReadSize)
+	var bytesRead uintptr
+	status, err := NtReadVirtualMemory(processHandle, params, unsafe.Pointer(&buffer[0]), paramsReadSize, &bytesRead)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("failed to read process parameters at 0x%X: %v (%s)", params, err, FormatNTStatus(status))
+	}
+	return buffer, nil
+}
+
+// environmentEnd returns the byte offset of the double NUL terminating an environment block,
+// or -1 when block does not contain it yet
+func environmentEnd(block []byte) int {
+	for offset := 0; offset+4 <= len(block); offset += 2 {
+		if block[offset] == 0 && block[offset+1] == 0 && block[offset+2] == 0 && block[offset+3] == 0 {
+			return offset + 2
+		}
+	}
+	return -1
+}
+
+// parseEnvironmentBlock splits a UTF-16 environment block into its NUL-separated strings
+func parseEnvironmentBlock(block []byte) []string {
+	if end := environmentEnd(block); end >= 0 {
+		block = block[:end]
+	}
+	chars := make([]uint16, len(block)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(block[2*i:])
+	}
+
+	var entries []string
+	for len(chars) > 0 {
+		end := 0
+		for end < len(chars) && chars[end] != 0 {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		entries = append(entries, string(utf16.Decode(chars[:end])))
+		if end == len(chars) {
+			break
+		}
+		chars = chars[end+1:]
+	}
+	return entries
+}
+
+// ProcessProtection is the PS_PROTECTION level of a process
+type ProcessProtection struct {
+	Type   uint8 // PS_PROTECTED_TYPE_*
+	Signer uint8 // PS_PROTECTED_SIGNER_*
+	Audit  bool
+}
+
+var protectionSignerNames = map[uint8]string{
+	PS_PROTECTED_SIGNER_AUTHENTICODE: "Authenticode",
+	PS_PROTECTED_SIGNER_CODEGEN:      "CodeGen",
+	PS_PROTECTED_SIGNER_ANTIMALWARE:  "Antimalware",
+	PS_PROTECTED_SIGNER_LSA:          "Lsa",
+	PS_PROTECTED_SIGNER_WINDOWS:      "Windows",
+	PS_PROTECTED_SIGNER_WINTCB:       "WinTcb",
+	PS_PROTECTED_SIGNER_WINSYSTEM:    "WinSystem",
+	PS_PROTECTED_SIGNER_APP:          "App",
+}
+
+// Protected reports whether the process is a protected or protected light process
+func (p ProcessProtection) Protected() bool {
+	return p.Type != PS_PROTECTED_TYPE_NONE
+}
+
+// String formats the level as e.g. "PPL-WinTcb" or "None"
+func (p ProcessProtection) String() string {
+	var kind string
+	switch p.Type {
+	case PS_PROTECTED_TYPE_NONE:
+		return "None"
+	case PS_PROTECTED_TYPE_PROTECTED_LIGHT:
+		kind = "PPL"
+	case PS_PROTECTED_TYPE_PROTECTED:
+		kind = "PP"
+	default:
+		kind = fmt.Sprintf("Type%d", p.Type)
+	}
+	signer, ok := protectionSignerNames[p.Signer]
+	if !ok {
+		signer = fmt.Sprintf("Signer%d", p.Signer)
+	}
+	return kind + "-" + signer
+}
+
+// ProcessDetails is the aggregated result of GetProcessInfo. Fields whose query was denied are
+// left at their zero value and the failure is recorded in Errors.
+type ProcessDetails struct {
+	PID       uint32
+	ParentPID uint32
+	Name      string
+	// ImagePath is the Win32 path of the executable, or its NT path when that has no Win32 form
+	ImagePath  string
+	SessionId  uint32
+	PebAddress uintptr
+	ExitStatus uint32 // STATUS_PENDING while the process runs
+	Wow64      bool
+	Protection ProcessProtection
+	// Integrity is the SECURITY_MANDATORY_*_RID of the primary token
+	Integrity uint32
+	UserSID   string
+	// UserName is only set for the well-known service accounts
+	UserName string
+	Elevated bool
+	// Errors maps the name of each failed query to its error
+	Errors map[string]error
+}
+
+// GetProcessInfo opens pid with PROCESS_QUERY_LIMITED_INFORMATION, which is granted even for
+// most protected processes, and gathers its basic information, image path, session, WOW64
+// state, protection level and token user, integrity and elevation in one call
+func GetProcessInfo(pid uint32) (*ProcessDetails, error) {
+	handle, err := openTargetProcess(pid, PROCESS_QUERY_LIMITED_INFORMATION)
+	if err != nil {
+		return nil, err
+	}
+	defer NtClose(handle)
+
+	var pbi PROCESS_BASIC_INFORMATION
+	var returnLength uintptr
+	status, err := NtQueryInformationProcess(handle, ProcessBasicInformation, unsafe.Pointer(&pbi), unsafe.Sizeof(pbi), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("NtQueryInformationProcess(ProcessBasicInformation) failed for PID %d: %v (%s)", pid, err, FormatNTStatus(status))
+	}
+
+	details := &ProcessDetails{
+		PID:        uint32(pbi.UniqueProcessId),
+		ParentPID:  uint32(pbi.InheritedFromUniqueProcessId),
+		PebAddress: pbi.PebBaseAddress,
+		ExitStatus: uint32(pbi.ExitStatus),
+		Errors:     make(map[string]error),
+	}
+
+	if path, err := queryProcessString(handle, ProcessImageFileNameWin32); err == nil && path != "" {
+		details.ImagePath = path
+	} else if path, err = queryProcessString(handle, ProcessImageFileName); err == nil {
+		details.ImagePath = path
+	} else {
+		details.Errors["image path"] = err
+	}
+	if details.ImagePath != "" {
+		details.Name = filepath.Base(details.ImagePath)
+	}
+
+	var session uint32
+	status, err = NtQueryInformationProcess(handle, ProcessSessionInformation, unsafe.Pointer(&session), unsafe.Sizeof(session), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		details.Errors["session"] = fmt.Errorf("NtQueryInformationProcess(ProcessSessionInformation) failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	details.SessionId = session
+
+	if peb32, err := remoteWow64PEB(handle); err == nil {
+		details.Wow64 = peb32 != 0
+	} else {
+		details.Errors["wow64"] = err
+	}
+
+	var protection uint8
+	status, err = NtQueryInformationProcess(handle, ProcessProtectionInformation, unsafe.Pointer(&protection), unsafe.Sizeof(protection), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		details.Errors["protection"] = fmt.Errorf("NtQueryInformationProcess(ProcessProtectionInformation) failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	details.Protection = ProcessProtection{
+		Type:   protection & 0x7,
+		Audit:  protection&0x8 != 0,
+		Signer: protection >> 4,
+	}
+
+	var token uintptr
+	status, err = NtOpenProcessToken(handle, TOKEN_QUERY, &token)
+	if err != nil || status != STATUS_SUCCESS {
+		details.Errors["token"] = fmt.Errorf("NtOpenProcessToken failed: %v (%s)", err, FormatNTStatus(status))
+		return details, nil
+	}
+	defer NtClose(token)
+
+	if sid, err := tokenUserSID(token); err == nil {
+		details.UserSID = sid
+		details.UserName = wellKnownAccounts[sid]
+	} else {
+		details.Errors["user"] = err
+	}
+	if details.Integrity, err = tokenIntegrityRID(token); err != nil {
+		details.Errors["integrity"] = err
+	}
+	if details.Elevated, err = tokenElevated(token); err != nil {
+		details.Errors["elevation"] = err
+	}
+	return details, nil
+}
@@ -0,0 +1,110 @@
+// Package winapi - Secondary Logon Module
+// Provides credential logon and process creation as another user without CreateProcessWithLogonW
+package winapi
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/obf"
+	"github.com/carved4/go-native-syscall/pkg/syscall"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+// splitAccountName splits DOMAIN\user and user@domain forms; a bare name is a local account
+func splitAccountName(username string) (user string, domain string) {
+	if i := strings.IndexByte(username, '\\'); i >= 0 {
+		return username[i+1:], username[:i]
+	}
+	if strings.Contains(username, "@") {
+		return username, "" // UPN: LogonUserW expects a NULL domain
+	}
+	return username, "."
+}
+
+// LogonUser logs a user on with a password through advapi32!LogonUserW, resolved at runtime,
+// and returns the resulting token. username is DOMAIN\user, user@domain or a local account
+// name; logonType is one of the LOGON32_LOGON_* values. Interactive, batch and service logons
+// return a primary token; network logons return an impersonation token. The caller closes it.
+func LogonUser(username string, password string, logonType uint32) (uintptr, error) {
+	advapi32 := syscallresolve.GetModuleBase(obf.GetHash("advapi32.dll"))
+	if advapi32 == 0 {
+		advapi32 = syscall.LoadLibraryW("advapi32.dll")
+	}
+	logonUser := syscallresolve.GetFunctionAddress(advapi32, obf.GetHash("LogonUserW"))
+	if logonUser == 0 {
+		return 0, fmt.Errorf("failed to resolve advapi32!LogonUserW")
+	}
+
+	user, domain := splitAccountName(username)
+	userUTF16 := StringToUTF16(user)
+	passwordUTF16 := StringToUTF16(password)
+	var domainUTF16 *uint16
+	if domain != "" {
+		domainUTF16 = StringToUTF16(domain)
+	}
+
+	// Keep the call and the last-error read on one OS thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var token uintptr
+	ok, _ := syscall.DirectCall(logonUser,
+		uintptr(unsafe.Pointer(userUTF16)),
+		uintptr(unsafe.Pointer(domainUTF16)),
+		uintptr(unsafe.Pointer(passwordUTF16)),
+		uintptr(logonType),
+		LOGON32_PROVIDER_DEFAULT,
+		uintptr(unsafe.Pointer(&token)),
+	)
+	runtime.KeepAlive(userUTF16)
+	runtime.KeepAlive(domainUTF16)
+
+	// The UTF-16 copy of the password is ours to wipe; the caller's string is left alone
+	passwordLength := uintptr(0)
+	for *(*uint16)(unsafe.Add(unsafe.Pointer(passwordUTF16), passwordLength*2)) != 0 {
+		passwordLength++
+	}
+	WipeMemory(uintptr(unsafe.Pointer(passwordUTF16)), passwordLength*2)
+	runtime.KeepAlive(passwordUTF16)
+
+	if uint32(ok) == 0 || token == 0 {
+		return 0, fmt.Errorf("LogonUserW failed for %s: %s", username, lastWin32Error())
+	}
+	debug.Printfln("WINAPI", "Logged on %s (type %d)\n", username, logonType)
+	return token, nil
+}
+
+// RunAs logs username on interactively and starts imagePath as that user with
+// CreateProcessWithTokenNative. Unlike CreateProcessWithLogonW there is no secondary logon
+// service involved: the caller needs SeAssignPrimaryTokenPrivilege (held by SYSTEM and service
+// accounts), which RunAs enables before the logon and fails without. GUI programs may also
+// need access to the caller's window station and desktop, which is not granted here.
+func RunAs(username string, password string, imagePath string) (*CreatedProcess, error) {
+	if err := EnablePrivilege(SE_ASSIGNPRIMARYTOKEN_PRIVILEGE); err != nil {
+		return nil, fmt.Errorf("RunAs requires SeAssignPrimaryTokenPrivilege: %w", err)
+	}
+
+	token, err := LogonUser(username, password, LOGON32_LOGON_INTERACTIVE)
+	if err != nil {
+		return nil, err
+	}
+	defer NtClose(token)
+
+	return CreateProcessWithTokenNative(token, imagePath, nil, ProcessOptions{})
+}
+
+// lastWin32Error returns the last error of the current OS thread via ntdll!RtlGetLastWin32Error.
+// The caller must hold runtime.LockOSThread since the failing call.
+func lastWin32Error() string {
+	ntdll := syscallresolve.GetModuleBase(obf.GetHash("ntdll.dll"))
+	getLastError := syscallresolve.GetFunctionAddress(ntdll, obf.GetHash("RtlGetLastWin32Error"))
+	if getLastError == 0 {
+		return "unknown error"
+	}
+	code, _ := syscall.DirectCall(getLastError, 0) // DirectCall needs at least one argument
+	return fmt.Sprintf("Win32 error %d", uint32(code))
+}
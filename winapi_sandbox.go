@@ -0,0 +1,133 @@
+// Package winapi - Token Sandbox Module
+// Provides restricted and AppContainer (lowbox) tokens for running children with reduced rights
+package winapi
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// RestrictedTokenOptions selects how CreateRestrictedToken filters a token
+type RestrictedTokenOptions struct {
+	// DisableMaxPrivilege removes every privilege except SeChangeNotifyPrivilege
+	DisableMaxPrivilege bool
+	// LuaToken produces a UAC-style filtered token (administrators group deny-only, etc.)
+	LuaToken bool
+	// WriteRestricted applies RestrictSids to write access only
+	WriteRestricted bool
+	// DisableSids become deny-only groups in the new token
+	DisableSids []Sid
+	// RestrictSids are added as restricting SIDs: access must be granted to both the normal
+	// groups and one of these
+	RestrictSids []Sid
+	// DeletePrivileges lists privilege LUIDs (SE_*_PRIVILEGE) to remove
+	DeletePrivileges []uint32
+}
+
+// CreateRestrictedToken creates a filtered copy of tokenHandle (which needs TOKEN_DUPLICATE)
+// with NtFilterToken. The result is a primary token that CreateProcessWithTokenNative can
+// assign without SeAssignPrimaryTokenPrivilege when tokenHandle is the caller's own token.
+// The caller closes the returned handle.
+func CreateRestrictedToken(tokenHandle uintptr, opts RestrictedTokenOptions) (uintptr, error) {
+	var flags uintptr
+	if opts.DisableMaxPrivilege {
+		flags |= DISABLE_MAX_PRIVILEGE
+	}
+	if opts.LuaToken {
+		flags |= LUA_TOKEN
+	}
+	if opts.WriteRestricted {
+		flags |= WRITE_RESTRICTED
+	}
+
+	disable := buildTokenGroups(opts.DisableSids, 0)
+	restrict := buildTokenGroups(opts.RestrictSids, 0)
+	var privileges []byte
+	if len(opts.DeletePrivileges) > 0 {
+		entrySize := unsafe.Sizeof(LUID_AND_ATTRIBUTES{})
+		first := unsafe.Offsetof(TOKEN_PRIVILEGES{}.Privileges)
+		privileges = make([]byte, first+uintptr(len(opts.DeletePrivileges))*entrySize)
+		*(*uint32)(unsafe.Pointer(&privileges[0])) = uint32(len(opts.DeletePrivileges))
+		for i, luid := range opts.DeletePrivileges {
+			entry := (*LUID_AND_ATTRIBUTES)(unsafe.Pointer(&privileges[first+uintptr(i)*entrySize]))
+			entry.Luid.LowPart = luid
+		}
+	}
+
+	var restricted uintptr
+	status, err := NtFilterToken(tokenHandle, flags, bufferAddress(disable), bufferAddress(privileges), bufferAddress(restrict), &restricted)
+	runtime.KeepAlive(disable)
+	runtime.KeepAlive(restrict)
+	runtime.KeepAlive(privileges)
+	runtime.KeepAlive(opts)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtFilterToken failed: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	debug.Printfln("TOKEN", "Created restricted token (flags 0x%X, %d restricting SIDs)\n", flags, len(opts.RestrictSids))
+	return restricted, nil
+}
+
+// CreateLowBoxToken creates an AppContainer token from tokenHandle (which needs TOKEN_DUPLICATE)
+// for packageSid, an S-1-15-2-... package SID, granting capabilities (see CapabilitySid). The
+// per-package named object directory that kernelbase normally creates is not set up, so
+// children that use named kernel objects may fail. The caller closes the returned handle.
+func CreateLowBoxToken(tokenHandle uintptr, packageSid Sid, capabilities []Sid) (uintptr, error) {
+	if len(packageSid) < 12 || packageSid[7] != 15 {
+		return 0, fmt.Errorf("%s is not an AppContainer package SID", packageSid)
+	}
+
+	// NtCreateLowBoxToken takes a bare SID_AND_ATTRIBUTES array, without the TOKEN_GROUPS count
+	groups := buildTokenGroups(capabilities, SE_GROUP_ENABLED)
+	var capabilityArray uintptr
+	if len(capabilities) > 0 {
+		capabilityArray = uintptr(unsafe.Pointer(&groups[unsafe.Sizeof(uintptr(0))]))
+	}
+	objAttr := OBJECT_ATTRIBUTES{Length: uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{}))}
+
+	var lowbox uintptr
+	status, err := NtCreateLowBoxToken(&lowbox, tokenHandle, TOKEN_ALL_ACCESS, uintptr(unsafe.Pointer(&objAttr)), packageSid.Pointer(), uintptr(len(capabilities)), capabilityArray, 0, 0)
+	runtime.KeepAlive(groups)
+	runtime.KeepAlive(capabilities)
+	runtime.KeepAlive(packageSid)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtCreateLowBoxToken failed: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	debug.Printfln("TOKEN", "Created lowbox token for %s with %d capabilities\n", packageSid, len(capabilities))
+	return lowbox, nil
+}
+
+// CapabilitySid returns the capability SID S-1-15-3-<rid> for a SECURITY_CAPABILITY_* value
+func CapabilitySid(rid uint32) Sid {
+	return mustSid(15, 3, rid)
+}
+
+// buildTokenGroups lays out a TOKEN_GROUPS structure for sids, or returns nil when there are
+// none. The entries point into sids, which must stay reachable while the buffer is used.
+func buildTokenGroups(sids []Sid, attributes uint32) []byte {
+	if len(sids) == 0 {
+		return nil
+	}
+	header := unsafe.Sizeof(uintptr(0)) // GroupCount padded to pointer alignment
+	entrySize := unsafe.Sizeof(SID_AND_ATTRIBUTES{})
+	buffer := make([]byte, header+uintptr(len(sids))*entrySize)
+	*(*uint32)(unsafe.Pointer(&buffer[0])) = uint32(len(sids))
+	for i, sid := range sids {
+		entry := (*SID_AND_ATTRIBUTES)(unsafe.Pointer(&buffer[header+uintptr(i)*entrySize]))
+		entry.Sid = sid.Pointer()
+		entry.Attributes = attributes
+	}
+	return buffer
+}
+
+// bufferAddress returns the address of buffer, or 0 when it is empty
+func bufferAddress(buffer []byte) uintptr {
+	if len(buffer) == 0 {
+		return 0
+	}
+	return uintptr(unsafe.Pointer(&buffer[0]))
+}
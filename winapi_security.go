@@ -0,0 +1,236 @@
+// Package winapi - Security Descriptor Module
+// Provides SID, DACL and SECURITY_DESCRIPTOR construction through ntdll Rtl* routines
+package winapi
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/obf"
+	"github.com/carved4/go-native-syscall/pkg/syscall"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+// Sid is a self-relative SID in its binary form
+type Sid []byte
+
+// Well-known SIDs
+var (
+	SidEveryone           = mustSid(1, 0)
+	SidAuthenticatedUsers = mustSid(5, 11)
+	SidLocalSystem        = mustSid(5, 18)
+	SidLocalService       = mustSid(5, 19)
+	SidNetworkService     = mustSid(5, 20)
+	SidAdministrators     = mustSid(5, 32, 544)
+	SidUsers              = mustSid(5, 32, 545)
+)
+
+// NewSid builds a SID from its identifier authority (e.g. 5 for NT AUTHORITY) and
+// sub-authorities
+func NewSid(authority uint64, subAuthorities ...uint32) (Sid, error) {
+	if len(subAuthorities) == 0 || len(subAuthorities) > 15 {
+		return nil, fmt.Errorf("a SID needs 1 to 15 sub-authorities, got %d", len(subAuthorities))
+	}
+	if authority >= 1<<48 {
+		return nil, fmt.Errorf("identifier authority 0x%X does not fit in 48 bits", authority)
+	}
+
+	sid := make(Sid, 8+4*len(subAuthorities))
+	sid[0] = 1 // SID_REVISION
+	sid[1] = byte(len(subAuthorities))
+	for i := 0; i < 6; i++ {
+		sid[7-i] = byte(authority >> (8 * i))
+	}
+	for i, sub := range subAuthorities {
+		*(*uint32)(unsafe.Pointer(&sid[8+4*i])) = sub
+	}
+
+	valid, _ := callRtl("RtlValidSid", sid.Pointer())
+	runtime.KeepAlive(sid)
+	if uint8(valid) == 0 {
+		return nil, fmt.Errorf("RtlValidSid rejected the SID")
+	}
+	return sid, nil
+}
+
+// ParseSid builds a SID from its string form, e.g. "S-1-5-32-544"
+func ParseSid(s string) (Sid, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || !strings.EqualFold(parts[0], "S") || parts[1] != "1" {
+		return nil, fmt.Errorf("invalid SID string %q", s)
+	}
+	authority, err := strconv.ParseUint(parts[2], 10, 48)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SID authority in %q: %v", s, err)
+	}
+	subAuthorities := make([]uint32, 0, len(parts)-3)
+	for _, part := range parts[3:] {
+		sub, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SID sub-authority in %q: %v", s, err)
+		}
+		subAuthorities = append(subAuthorities, uint32(sub))
+	}
+	return NewSid(authority, subAuthorities...)
+}
+
+// IntegritySid returns the mandatory label SID S-1-16-<rid> for a SECURITY_MANDATORY_*_RID
+func IntegritySid(rid uint32) Sid {
+	return mustSid(16, rid)
+}
+
+func mustSid(authority uint64, subAuthorities ...uint32) Sid {
+	sid := make(Sid, 8+4*len(subAuthorities))
+	sid[0] = 1
+	sid[1] = byte(len(subAuthorities))
+	sid[7] = byte(authority)
+	for i, sub := range subAuthorities {
+		*(*uint32)(unsafe.Pointer(&sid[8+4*i])) = sub
+	}
+	return sid
+}
+
+// String returns the SID in S-R-I-S... form
+func (s Sid) String() string {
+	if len(s) < 8 {
+		return ""
+	}
+	return sidToString(s.Pointer())
+}
+
+// Pointer returns the address of the SID; it is valid while s is reachable
+func (s Sid) Pointer() uintptr {
+	if len(s) == 0 {
+		return 0
+	}
+	return uintptr(unsafe.Pointer(&s[0]))
+}
+
+// Ace is one access control entry of a DACL built by BuildDacl
+type Ace struct {
+	// Deny makes this an access-denied entry; the default is access-allowed
+	Deny bool
+	// Mask is the access mask granted or denied (e.g. GENERIC_ALL, EVENT_ALL_ACCESS)
+	Mask uint32
+	// Flags are ACE inheritance flags (OBJECT_INHERIT_ACE, CONTAINER_INHERIT_ACE, ...)
+	Flags uint32
+	Sid   Sid
+}
+
+// BuildDacl builds an ACL from entries with RtlCreateAcl and RtlAddAccess{Allowed,Denied}AceEx.
+// Entries are added in order; put deny entries first to get the canonical evaluation order.
+func BuildDacl(entries []Ace) ([]byte, error) {
+	size := uintptr(8) // ACL header
+	for _, entry := range entries {
+		if len(entry.Sid) < 8 {
+			return nil, fmt.Errorf("ACE has no SID")
+		}
+		size += 8 + uintptr(len(entry.Sid)) // ACE header + mask, then the SID
+	}
+	size = (size + 3) &^ 3
+
+	acl := make([]byte, size)
+	aclPtr := uintptr(unsafe.Pointer(&acl[0]))
+	status, err := callRtl("RtlCreateAcl", aclPtr, size, ACL_REVISION)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("RtlCreateAcl failed: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	for i, entry := range entries {
+		routine := "RtlAddAccessAllowedAceEx"
+		if entry.Deny {
+			routine = "RtlAddAccessDeniedAceEx"
+		}
+		status, err := callRtl(routine, aclPtr, ACL_REVISION, uintptr(entry.Flags), uintptr(entry.Mask), entry.Sid.Pointer())
+		runtime.KeepAlive(entry.Sid)
+		if err != nil || status != STATUS_SUCCESS {
+			return nil, fmt.Errorf("%s failed for entry %d (%s): %v (%s)", routine, i, entry.Sid, err, FormatNTStatus(status))
+		}
+	}
+	return acl, nil
+}
+
+// SecurityDescriptor is an absolute SECURITY_DESCRIPTOR together with the DACL and owner it
+// points at. Pass Pointer() as OBJECT_ATTRIBUTES.SecurityDescriptor when creating an object.
+type SecurityDescriptor struct {
+	buffer []byte
+	dacl   []byte
+	owner  Sid
+}
+
+// NewSecurityDescriptor builds a security descriptor with dacl as its DACL. A nil dacl sets a
+// NULL DACL, which grants everyone full access; an empty but non-nil dacl denies everyone.
+func NewSecurityDescriptor(dacl []byte) (*SecurityDescriptor, error) {
+	sd := &SecurityDescriptor{
+		buffer: make([]byte, SECURITY_DESCRIPTOR_MIN_LENGTH),
+		dacl:   dacl,
+	}
+	if dacl != nil && len(dacl) == 0 {
+		empty, err := BuildDacl(nil)
+		if err != nil {
+			return nil, err
+		}
+		sd.dacl = empty
+	}
+
+	status, err := callRtl("RtlCreateSecurityDescriptor", sd.Pointer(), SECURITY_DESCRIPTOR_REVISION)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("RtlCreateSecurityDescriptor failed: %v (%s)", err, FormatNTStatus(status))
+	}
+
+	var daclPtr uintptr
+	if sd.dacl != nil {
+		daclPtr = uintptr(unsafe.Pointer(&sd.dacl[0]))
+	}
+	status, err = callRtl("RtlSetDaclSecurityDescriptor", sd.Pointer(), 1, daclPtr, 0)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("RtlSetDaclSecurityDescriptor failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return sd, nil
+}
+
+// PermissiveSecurityDescriptor returns a descriptor with a NULL DACL (full access for everyone)
+func PermissiveSecurityDescriptor() (*SecurityDescriptor, error) {
+	return NewSecurityDescriptor(nil)
+}
+
+// RestrictiveSecurityDescriptor returns a descriptor whose DACL grants GENERIC_ALL to sids only
+func RestrictiveSecurityDescriptor(sids ...Sid) (*SecurityDescriptor, error) {
+	entries := make([]Ace, 0, len(sids))
+	for _, sid := range sids {
+		entries = append(entries, Ace{Mask: GENERIC_ALL, Sid: sid})
+	}
+	dacl, err := BuildDacl(entries)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecurityDescriptor(dacl)
+}
+
+// SetOwner sets the owner SID of the descriptor
+func (sd *SecurityDescriptor) SetOwner(owner Sid) error {
+	sd.owner = owner
+	status, err := callRtl("RtlSetOwnerSecurityDescriptor", sd.Pointer(), owner.Pointer(), 0)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("RtlSetOwnerSecurityDescriptor failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return nil
+}
+
+// Pointer returns the address of the SECURITY_DESCRIPTOR; it is valid while sd is reachable
+func (sd *SecurityDescriptor) Pointer() uintptr {
+	return uintptr(unsafe.Pointer(&sd.buffer[0]))
+}
+
+// callRtl resolves an ntdll export by name and calls it
+func callRtl(name string, args ...uintptr) (uintptr, error) {
+	ntdll := syscallresolve.GetModuleBase(obf.GetHash("ntdll.dll"))
+	address := syscallresolve.GetFunctionAddress(ntdll, obf.GetHash(name))
+	if address == 0 {
+		return 0, fmt.Errorf("failed to resolve ntdll!%s", name)
+	}
+	return syscall.DirectCall(address, args...)
+}
@@ -0,0 +1,210 @@
+// Package winapi - Target Selection Module
+// Provides ranked discovery of processes suitable for a given injection configuration
+package winapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+	"github.com/carved4/go-native-syscall/pkg/syscallresolve"
+)
+
+// TargetFilter narrows and weights the candidates returned by FindInjectionTargets.
+// The zero value accepts every process that can be opened for a default Inject call.
+type TargetFilter struct {
+	// Names restricts candidates to these image names (case-insensitive, e.g. "notepad.exe")
+	Names []string
+	// RequiredAccess is the access mask a candidate must grant; 0 uses what Inject needs
+	// for its default options
+	RequiredAccess uintptr
+	// SameSession drops candidates outside the caller's session
+	SameSession bool
+	// MaxIntegrity drops candidates above this integrity RID (e.g. SECURITY_MANDATORY_MEDIUM_RID);
+	// 0 means no limit
+	MaxIntegrity uint32
+	// RequireModules lists DLLs the payload depends on (e.g. "clr.dll", "wininet.dll").
+	// Candidates that have them loaded score higher.
+	RequireModules []string
+	// IncludeSelf keeps the calling process in the result
+	IncludeSelf bool
+}
+
+// InjectionTarget is a scored candidate process
+type InjectionTarget struct {
+	PID            uint32
+	Name           string
+	SessionId      uint32
+	Wow64          bool
+	Integrity      uint32 // integrity RID, 0 if it could not be read
+	MissingModules []string
+	Score          int
+	// Reasons explains how the score was built, one entry per factor
+	Reasons []string
+}
+
+// Score weights used by FindInjectionTargets
+const (
+	targetScoreSameSession     = 20
+	targetScoreSameIntegrity   = 15
+	targetScoreLowerIntegrity  = 5
+	targetScoreModulePresent   = 10
+	targetPenaltyModuleMissing = 10
+	targetPenaltyModulesUnread = 5
+)
+
+// FindInjectionTargets enumerates processes and returns those that match filter, can be opened
+// with the required access and share the caller's architecture, ranked by score (highest first).
+func FindInjectionTargets(filter TargetFilter) ([]InjectionTarget, error) {
+	buffer, err := querySystemInformation(SystemProcessInformation)
+	if err != nil {
+		return nil, err
+	}
+
+	required := filter.RequiredAccess
+	if required == 0 {
+		required = injectRequiredAccess(InjectOptions{})
+	}
+
+	selfPID := uint32(GetCurrentProcessId())
+	selfSession := uint32(0)
+	if peb := syscallresolve.GetCurrentProcessPEB(); peb != nil {
+		selfSession = peb.SessionId
+	}
+	selfIntegrity, _ := processIntegrityRID(CURRENT_PROCESS)
+
+	names := make(map[string]bool, len(filter.Names))
+	for _, name := range filter.Names {
+		names[strings.ToLower(name)] = true
+	}
+
+	type entry struct {
+		pid     uint32
+		name    string
+		session uint32
+	}
+	var entries []entry
+	walkProcessEntries(buffer, func(proc *SYSTEM_PROCESS_INFORMATION, _ []SYSTEM_THREAD_INFORMATION) bool {
+		pid := uint32(proc.UniqueProcessId)
+		if pid == 0 || pid == 4 {
+			return true
+		}
+		name := ""
+		if proc.ImageName.Buffer != nil && proc.ImageName.Length > 0 {
+			name = utf16ToString(proc.ImageName.Buffer, int(proc.ImageName.Length/2))
+		}
+		entries = append(entries, entry{pid: pid, name: name, session: proc.SessionId})
+		return true
+	})
+
+	var targets []InjectionTarget
+	for _, e := range entries {
+		if e.pid == selfPID && !filter.IncludeSelf {
+			continue
+		}
+		if len(names) > 0 && !names[strings.ToLower(e.name)] {
+			continue
+		}
+		if filter.SameSession && e.session != selfSession {
+			continue
+		}
+
+		target, ok := scoreTarget(e.pid, e.name, e.session, required, selfSession, selfIntegrity, filter)
+		if ok {
+			targets = append(targets, target)
+		}
+	}
+
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i].Score > targets[j].Score
+	})
+
+	debug.Printfln("TARGETS", "%d of %d processes are usable injection targets\n", len(targets), len(entries))
+	return targets, nil
+}
+
+// scoreTarget opens pid and scores it; ok is false when the process must be skipped
+func scoreTarget(pid uint32, name string, session uint32, required uintptr, selfSession uint32, selfIntegrity uint32, filter TargetFilter) (InjectionTarget, bool) {
+	target := InjectionTarget{PID: pid, Name: name, SessionId: session}
+
+	// Opening with the required mask is the actual access check
+	handle, err := openTargetProcess(pid, required|PROCESS_QUERY_LIMITED_INFORMATION|PROCESS_VM_READ)
+	modulesReadable := err == nil
+	if err != nil {
+		handle, err = openTargetProcess(pid, required|PROCESS_QUERY_LIMITED_INFORMATION)
+		if err != nil {
+			return target, false
+		}
+	}
+	defer NtClose(handle)
+
+	var wow64 uintptr
+	var returnLength uintptr
+	status, err := NtQueryInformationProcess(handle, ProcessWow64Information, unsafe.Pointer(&wow64), unsafe.Sizeof(wow64), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		return target, false
+	}
+	target.Wow64 = wow64 != 0
+	if target.Wow64 {
+		return target, false // 32-bit target, the x64 payload and stubs cannot run there
+	}
+	target.Reasons = append(target.Reasons, "architecture matches (x64)")
+
+	if session == selfSession {
+		target.Score += targetScoreSameSession
+		target.Reasons = append(target.Reasons, "same session")
+	}
+
+	if integrity, err := processIntegrityRID(handle); err == nil {
+		target.Integrity = integrity
+		if filter.MaxIntegrity != 0 && integrity > filter.MaxIntegrity {
+			return target, false
+		}
+		switch {
+		case selfIntegrity != 0 && integrity == selfIntegrity:
+			target.Score += targetScoreSameIntegrity
+			target.Reasons = append(target.Reasons, fmt.Sprintf("same integrity (0x%X)", integrity))
+		case selfIntegrity != 0 && integrity < selfIntegrity:
+			target.Score += targetScoreLowerIntegrity
+			target.Reasons = append(target.Reasons, fmt.Sprintf("lower integrity (0x%X)", integrity))
+		}
+	}
+
+	if len(filter.RequireModules) > 0 {
+		if !modulesReadable {
+			target.Score -= targetPenaltyModulesUnread
+			target.Reasons = append(target.Reasons, "module list not readable")
+			target.MissingModules = append(target.MissingModules, filter.RequireModules...)
+		} else {
+			modules, _ := readRemoteModules(handle)
+			loaded := make(map[string]bool, len(modules))
+			for _, module := range modules {
+				loaded[strings.ToLower(module.Name)] = true
+			}
+			for _, want := range filter.RequireModules {
+				if loaded[strings.ToLower(want)] {
+					target.Score += targetScoreModulePresent
+					target.Reasons = append(target.Reasons, want+" loaded")
+				} else {
+					target.Score -= targetPenaltyModuleMissing
+					target.MissingModules = append(target.MissingModules, want)
+				}
+			}
+		}
+	}
+
+	return target, true
+}
+
+func openTargetProcess(pid uint32, access uintptr) (uintptr, error) {
+	var handle uintptr
+	clientId := CLIENT_ID{UniqueProcess: uintptr(pid)}
+	objAttr := OBJECT_ATTRIBUTES{Length: uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{}))}
+	status, err := NtOpenProcess(&handle, access, uintptr(unsafe.Pointer(&objAttr)), uintptr(unsafe.Pointer(&clientId)))
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtOpenProcess failed for PID %d: %v (%s)", pid, err, FormatNTStatus(status))
+	}
+	return handle, nil
+}
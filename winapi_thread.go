@@ -0,0 +1,199 @@
+// Package winapi - Thread Creation Module
+// Provides a typed NtCreateThreadEx wrapper and PS_ATTRIBUTE_LIST construction helpers
+package winapi
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// PsAttributeList builds a PS_ATTRIBUTE_LIST for NtCreateThreadEx and NtCreateUserProcess.
+// The list keeps references to the values it points at until the call that consumes it returns.
+type PsAttributeList struct {
+	attributes []PS_ATTRIBUTE
+	buffer     []uintptr
+	keep       []interface{}
+}
+
+// NewPsAttributeList returns an empty attribute list
+func NewPsAttributeList() *PsAttributeList {
+	return &PsAttributeList{}
+}
+
+// Add appends a raw attribute. value is either the attribute value itself or a pointer to a
+// buffer of size bytes, depending on the attribute; keep holds any Go object value points into.
+func (l *PsAttributeList) Add(attribute uintptr, size uintptr, value uintptr, keep interface{}) {
+	l.attributes = append(l.attributes, PS_ATTRIBUTE{
+		Attribute: attribute,
+		Size:      size,
+		Value:     value,
+	})
+	if keep != nil {
+		l.keep = append(l.keep, keep)
+	}
+}
+
+// AddClientId requests the new thread's CLIENT_ID to be written to clientId
+func (l *PsAttributeList) AddClientId(clientId *CLIENT_ID) {
+	l.Add(PS_ATTRIBUTE_CLIENT_ID, unsafe.Sizeof(*clientId), uintptr(unsafe.Pointer(clientId)), clientId)
+}
+
+// AddTebAddress requests the new thread's TEB address to be written to teb
+func (l *PsAttributeList) AddTebAddress(teb *uintptr) {
+	l.Add(PS_ATTRIBUTE_TEB_ADDRESS, unsafe.Sizeof(*teb), uintptr(unsafe.Pointer(teb)), teb)
+}
+
+// clone returns a copy of l that calls can extend with their own attributes, so the caller's
+// list can be reused across calls. A nil l yields an empty list.
+func (l *PsAttributeList) clone() *PsAttributeList {
+	c := NewPsAttributeList()
+	if l != nil {
+		c.attributes = append(c.attributes, l.attributes...)
+		c.keep = append(c.keep, l.keep...)
+	}
+	return c
+}
+
+// Len returns the number of attributes in the list
+func (l *PsAttributeList) Len() int {
+	return len(l.attributes)
+}
+
+// Pointer lays the list out in native PS_ATTRIBUTE_LIST form (TotalLength followed by the
+// attributes) and returns its address, or 0 for an empty list. The returned address is valid
+// until the list is modified or becomes unreachable.
+func (l *PsAttributeList) Pointer() uintptr {
+	if len(l.attributes) == 0 {
+		return 0
+	}
+
+	entryWords := unsafe.Sizeof(PS_ATTRIBUTE{}) / unsafe.Sizeof(uintptr(0))
+	l.buffer = make([]uintptr, 1+uintptr(len(l.attributes))*entryWords)
+	l.buffer[0] = unsafe.Sizeof(uintptr(0)) + uintptr(len(l.attributes))*unsafe.Sizeof(PS_ATTRIBUTE{})
+
+	entries := unsafe.Slice((*PS_ATTRIBUTE)(unsafe.Pointer(&l.buffer[1])), len(l.attributes))
+	copy(entries, l.attributes)
+	return uintptr(unsafe.Pointer(&l.buffer[0]))
+}
+
+// ThreadOptions configures CreateThread. The zero value creates a running thread with
+// THREAD_ALL_ACCESS, default stack sizes and no argument.
+type ThreadOptions struct {
+	Argument         uintptr
+	CreateSuspended  bool
+	SkipThreadAttach bool // don't call DllMain(DLL_THREAD_ATTACH) for the new thread
+	HideFromDebugger bool // sets THREAD_CREATE_FLAGS_HIDE_FROM_DEBUGGER
+	DesiredAccess    uintptr
+	ZeroBits         uintptr
+	StackSize        uintptr // committed stack size, 0 for the image default
+	MaxStackSize     uintptr // reserved stack size, 0 for the image default
+	// Attributes are passed in addition to the CLIENT_ID and TEB attributes CreateThread adds itself
+	Attributes *PsAttributeList
+	// Indirect issues the syscall through NtCreateThreadExIndirect
+	Indirect bool
+}
+
+// ThreadInfo describes a thread created by CreateThread
+type ThreadInfo struct {
+	Handle     uintptr
+	ProcessId  uintptr
+	ThreadId   uintptr
+	TebAddress uintptr
+}
+
+func (o ThreadOptions) createFlags() uintptr {
+	var flags uintptr
+	if o.CreateSuspended {
+		flags |= THREAD_CREATE_FLAGS_CREATE_SUSPENDED
+	}
+	if o.SkipThreadAttach {
+		flags |= THREAD_CREATE_FLAGS_SKIP_THREAD_ATTACH
+	}
+	if o.HideFromDebugger {
+		flags |= THREAD_CREATE_FLAGS_HIDE_FROM_DEBUGGER
+	}
+	return flags
+}
+
+// CreateThread creates a thread in processHandle starting at startAddress. The new thread's
+// client id and TEB address are always requested through the attribute list and returned.
+func CreateThread(processHandle uintptr, startAddress uintptr, opts ThreadOptions) (*ThreadInfo, error) {
+	info, status, err := createThread(processHandle, startAddress, opts)
+	if err != nil && status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("%v (%s)", err, FormatNTStatus(status))
+	}
+	return info, err
+}
+
+// createThread is CreateThread returning the NTSTATUS of NtCreateThreadEx separately
+func createThread(processHandle uintptr, startAddress uintptr, opts ThreadOptions) (*ThreadInfo, uintptr, error) {
+	if processHandle == 0 {
+		return nil, 0, fmt.Errorf("invalid process handle :(")
+	}
+	if startAddress == 0 {
+		return nil, 0, fmt.Errorf("invalid start address")
+	}
+
+	access := opts.DesiredAccess
+	if access == 0 {
+		access = THREAD_ALL_ACCESS
+	}
+
+	attributes := opts.Attributes.clone()
+
+	var clientId CLIENT_ID
+	var teb uintptr
+	attributes.AddClientId(&clientId)
+	attributes.AddTebAddress(&teb)
+	attributeList := attributes.Pointer()
+
+	create := NtCreateThreadEx
+	if opts.Indirect {
+		create = NtCreateThreadExIndirect
+	}
+
+	var threadHandle uintptr
+	status, err := create(
+		&threadHandle,
+		access,
+		0,
+		processHandle,
+		startAddress,
+		opts.Argument,
+		opts.createFlags(),
+		opts.ZeroBits,
+		opts.StackSize,
+		opts.MaxStackSize,
+		attributeList,
+	)
+	runtime.KeepAlive(attributes)
+
+	if err != nil || status != STATUS_SUCCESS || threadHandle == 0 {
+		return nil, status, fmt.Errorf("NtCreateThreadEx failed: %v", err)
+	}
+
+	info := &ThreadInfo{
+		Handle:     threadHandle,
+		ProcessId:  clientId.UniqueProcess,
+		ThreadId:   clientId.UniqueThread,
+		TebAddress: teb,
+	}
+
+	debug.Printfln("WINAPI", "Created thread %d in process %d (handle 0x%X, TEB 0x%X)\n", info.ThreadId, info.ProcessId, info.Handle, info.TebAddress)
+	return info, STATUS_SUCCESS, nil
+}
+
+// threadExitStatus returns the exit status of threadHandle, which is STATUS_PENDING while the
+// thread is still running. The handle needs THREAD_QUERY_LIMITED_INFORMATION.
+func threadExitStatus(threadHandle uintptr) (uint32, error) {
+	var info THREAD_BASIC_INFORMATION
+	var returnLength uintptr
+	status, err := NtQueryInformationThread(threadHandle, ThreadBasicInformation, unsafe.Pointer(&info), unsafe.Sizeof(info), &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtQueryInformationThread failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	return info.ExitStatus, nil
+}
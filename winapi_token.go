@@ -0,0 +1,452 @@
+// Package winapi - Token Information Module
+// Provides typed queries over process and thread tokens via NtQueryInformationToken
+package winapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// queryTokenInformation returns the raw buffer for infoClass, sized by a first probing call
+func queryTokenInformation(tokenHandle uintptr, infoClass uintptr) ([]byte, error) {
+	var returnLength uintptr
+	status, _ := NtQueryInformationToken(tokenHandle, infoClass, nil, 0, &returnLength)
+	if status != STATUS_BUFFER_TOO_SMALL && status != STATUS_INFO_LENGTH_MISMATCH && status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("NtQueryInformationToken(%d) failed: %s", infoClass, FormatNTStatus(status))
+	}
+	if returnLength == 0 {
+		return nil, fmt.Errorf("NtQueryInformationToken(%d) returned no data", infoClass)
+	}
+
+	buffer := make([]byte, returnLength)
+	status, err := NtQueryInformationToken(tokenHandle, infoClass, unsafe.Pointer(&buffer[0]), returnLength, &returnLength)
+	if err != nil || status != STATUS_SUCCESS {
+		return nil, fmt.Errorf("NtQueryInformationToken(%d) failed: %v (%s)", infoClass, err, FormatNTStatus(status))
+	}
+	return buffer, nil
+}
+
+// sidLastSubAuthority returns the final sub-authority of the SID at sid (the RID)
+func sidLastSubAuthority(sid uintptr) uint32 {
+	count := *(*uint8)(unsafe.Pointer(sid + 1))
+	if count == 0 {
+		return 0
+	}
+	return *(*uint32)(unsafe.Pointer(sid + 8 + uintptr(count-1)*4))
+}
+
+// tokenIntegrityRID returns the mandatory integrity RID of tokenHandle
+func tokenIntegrityRID(tokenHandle uintptr) (uint32, error) {
+	buffer, err := queryTokenInformation(tokenHandle, TokenIntegrityLevel)
+	if err != nil {
+		return 0, err
+	}
+	label := (*SID_AND_ATTRIBUTES)(unsafe.Pointer(&buffer[0]))
+	if label.Sid == 0 {
+		return 0, fmt.Errorf("token has no integrity label")
+	}
+	return sidLastSubAuthority(label.Sid), nil
+}
+
+// processIntegrityRID opens the primary token of processHandle and returns its integrity RID.
+// processHandle needs PROCESS_QUERY_LIMITED_INFORMATION.
+func processIntegrityRID(processHandle uintptr) (uint32, error) {
+	var token uintptr
+	status, err := NtOpenProcessToken(processHandle, TOKEN_QUERY, &token)
+	if err != nil || status != STATUS_SUCCESS {
+		return 0, fmt.Errorf("NtOpenProcessToken failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	defer NtClose(token)
+
+	return tokenIntegrityRID(token)
+}
+
+// sidToString formats the SID at sid in S-R-I-S... form
+func sidToString(sid uintptr) string {
+	revision := *(*uint8)(unsafe.Pointer(sid))
+	count := *(*uint8)(unsafe.Pointer(sid + 1))
+	var authority uint64
+	for i := uintptr(0); i < 6; i++ {
+		authority = authority<<8 | uint64(*(*uint8)(unsafe.Pointer(sid + 2 + i)))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "S-%d-%d", revision, authority)
+	for i := uintptr(0); i < uintptr(count); i++ {
+		fmt.Fprintf(&b, "-%d", *(*uint32)(unsafe.Pointer(sid + 8 + i*4)))
+	}
+	return b.String()
+}
+
+// wellKnownAccounts names the service account SIDs that can be resolved without LSA
+var wellKnownAccounts = map[string]string{
+	"S-1-5-18": `NT AUTHORITY\SYSTEM`,
+	"S-1-5-19": `NT AUTHORITY\LOCAL SERVICE`,
+	"S-1-5-20": `NT AUTHORITY\NETWORK SERVICE`,
+}
+
+// tokenUserSID returns the user SID of tokenHandle in string form
+func tokenUserSID(tokenHandle uintptr) (string, error) {
+	buffer, err := queryTokenInformation(tokenHandle, TokenUser)
+	if err != nil {
+		return "", err
+	}
+	user := (*SID_AND_ATTRIBUTES)(unsafe.Pointer(&buffer[0]))
+	if user.Sid == 0 {
+		return "", fmt.Errorf("token has no user SID")
+	}
+	return sidToString(user.Sid), nil
+}
+
+// tokenElevated reports whether tokenHandle is elevated (TokenElevation)
+func tokenElevated(tokenHandle uintptr) (bool, error) {
+	buffer, err := queryTokenInformation(tokenHandle, TokenElevation)
+	if err != nil {
+		return false, err
+	}
+	return *(*uint32)(unsafe.Pointer(&buffer[0])) != 0, nil
+}
+
+// IsElevated reports whether the current process token is elevated
+func IsElevated() (bool, error) {
+	token, err := getCurrentProcessToken()
+	if err != nil {
+		return false, err
+	}
+	defer NtClose(token)
+
+	return tokenElevated(token)
+}
+
+// GetTokenElevationType returns the TokenElevationType* value of tokenHandle, which needs
+// TOKEN_QUERY. TokenElevationTypeLimited means a filtered admin token whose elevated half is
+// available through GetLinkedToken.
+func GetTokenElevationType(tokenHandle uintptr) (uint32, error) {
+	buffer, err := queryTokenInformation(tokenHandle, TokenElevationType)
+	if err != nil {
+		return 0, err
+	}
+	return *(*uint32)(unsafe.Pointer(&buffer[0])), nil
+}
+
+// GetLinkedToken returns the other half of a split UAC token: the elevated token for a limited
+// one and the reverse. Without SeTcbPrivilege the linked token of a limited token is an
+// identification-level token, usable for queries but not for impersonation or process creation.
+// The caller closes the returned handle.
+func GetLinkedToken(tokenHandle uintptr) (uintptr, error) {
+	buffer, err := queryTokenInformation(tokenHandle, TokenLinkedToken)
+	if err != nil {
+		return 0, err
+	}
+	linked := *(*uintptr)(unsafe.Pointer(&buffer[0]))
+	if linked == 0 {
+		return 0, fmt.Errorf("token has no linked token")
+	}
+	return linked, nil
+}
+
+// GetIntegrityLevel returns the mandatory integrity RID (SECURITY_MANDATORY_*_RID) of the
+// primary token of processHandle, which needs PROCESS_QUERY_LIMITED_INFORMATION
+func GetIntegrityLevel(processHandle uintptr) (uint32, error) {
+	return processIntegrityRID(processHandle)
+}
+
+// IntegrityLevelName returns a readable name for an integrity RID
+func IntegrityLevelName(rid uint32) string {
+	switch {
+	case rid >= SECURITY_MANDATORY_PROTECTED_PROCESS_RID:
+		return "Protected"
+	case rid >= SECURITY_MANDATORY_SYSTEM_RID:
+		return "System"
+	case rid >= SECURITY_MANDATORY_HIGH_RID:
+		return "High"
+	case rid >= SECURITY_MANDATORY_MEDIUM_PLUS_RID:
+		return "MediumPlus"
+	case rid >= SECURITY_MANDATORY_MEDIUM_RID:
+		return "Medium"
+	case rid >= SECURITY_MANDATORY_LOW_RID:
+		return "Low"
+	}
+	return "Untrusted"
+}
+
+// SetTokenIntegrity sets the integrity label of tokenHandle to level (a SECURITY_MANDATORY_*_RID).
+// tokenHandle needs TOKEN_ADJUST_DEFAULT; lowering is always allowed, raising needs SeTcbPrivilege.
+// Use it on a duplicated primary token to start a child at low or medium integrity.
+func SetTokenIntegrity(tokenHandle uintptr, level uint32) error {
+	// TOKEN_MANDATORY_LABEL followed by the S-1-16-<level> SID it points at
+	labelSize := unsafe.Sizeof(SID_AND_ATTRIBUTES{})
+	buffer := make([]byte, labelSize+12)
+	sid := buffer[labelSize:]
+	sid[0] = 1  // revision
+	sid[1] = 1  // sub-authority count
+	sid[7] = 16 // SECURITY_MANDATORY_LABEL_AUTHORITY
+	*(*uint32)(unsafe.Pointer(&sid[8])) = level
+
+	label := (*SID_AND_ATTRIBUTES)(unsafe.Pointer(&buffer[0]))
+	label.Sid = uintptr(unsafe.Pointer(&sid[0]))
+	label.Attributes = SE_GROUP_INTEGRITY
+
+	status, err := NtSetInformationToken(tokenHandle, TokenIntegrityLevel, unsafe.Pointer(&buffer[0]), uintptr(len(buffer)))
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtSetInformationToken(TokenIntegrityLevel) failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	debug.Printfln("TOKEN", "Token integrity set to %s (0x%X)\n", IntegrityLevelName(level), level)
+	return nil
+}
+
+// ErrPrivilegeNotHeld is returned when a privilege is not present in the token at all, as
+// opposed to present but disabled
+var ErrPrivilegeNotHeld = errors.New("privilege not held by token")
+
+// privilegeNames maps privilege names to their well-known LUIDs
+var privilegeNames = map[string]uint32{
+	"SeCreateTokenPrivilege":          SE_CREATE_TOKEN_PRIVILEGE,
+	"SeAssignPrimaryTokenPrivilege":   SE_ASSIGNPRIMARYTOKEN_PRIVILEGE,
+	"SeLockMemoryPrivilege":           SE_LOCK_MEMORY_PRIVILEGE,
+	"SeIncreaseQuotaPrivilege":        SE_INCREASE_QUOTA_PRIVILEGE,
+	"SeMachineAccountPrivilege":       SE_MACHINE_ACCOUNT_PRIVILEGE,
+	"SeTcbPrivilege":                  SE_TCB_PRIVILEGE,
+	"SeSecurityPrivilege":             SE_SECURITY_PRIVILEGE,
+	"SeTakeOwnershipPrivilege":        SE_TAKE_OWNERSHIP_PRIVILEGE,
+	"SeLoadDriverPrivilege":           SE_LOAD_DRIVER_PRIVILEGE,
+	"SeSystemProfilePrivilege":        SE_SYSTEM_PROFILE_PRIVILEGE,
+	"SeSystemtimePrivilege":           SE_SYSTEMTIME_PRIVILEGE,
+	"SeProfileSingleProcessPrivilege": SE_PROF_SINGLE_PROCESS_PRIVILEGE,
+	"SeIncreaseBasePriorityPrivilege": SE_INC_BASE_PRIORITY_PRIVILEGE,
+	"SeCreatePagefilePrivilege":       SE_CREATE_PAGEFILE_PRIVILEGE,
+	"SeCreatePermanentPrivilege":      SE_CREATE_PERMANENT_PRIVILEGE,
+	"SeBackupPrivilege":               SE_BACKUP_PRIVILEGE,
+	"SeRestorePrivilege":              SE_RESTORE_PRIVILEGE,
+	"SeShutdownPrivilege":             SE_SHUTDOWN_PRIVILEGE,
+	"SeDebugPrivilege":                SE_DEBUG_PRIVILEGE,
+	"SeAuditPrivilege":                SE_AUDIT_PRIVILEGE,
+	"SeSystemEnvironmentPrivilege":    SE_SYSTEM_ENVIRONMENT_PRIVILEGE,
+	"SeChangeNotifyPrivilege":         SE_CHANGE_NOTIFY_PRIVILEGE,
+	"SeRemoteShutdownPrivilege":       SE_REMOTE_SHUTDOWN_PRIVILEGE,
+	"SeUndockPrivilege":               SE_UNDOCK_PRIVILEGE,
+	"SeSyncAgentPrivilege":            SE_SYNC_AGENT_PRIVILEGE,
+	"SeEnableDelegationPrivilege":     SE_ENABLE_DELEGATION_PRIVILEGE,
+	"SeManageVolumePrivilege":         SE_MANAGE_VOLUME_PRIVILEGE,
+	"SeImpersonatePrivilege":          SE_IMPERSONATE_PRIVILEGE,
+	"SeCreateGlobalPrivilege":         SE_CREATE_GLOBAL_PRIVILEGE,
+	"SeTrustedCredManAccessPrivilege": SE_TRUSTED_CREDMAN_ACCESS_PRIVILEGE,
+	"SeRelabelPrivilege":              SE_RELABEL_PRIVILEGE,
+	"SeIncreaseWorkingSetPrivilege":   SE_INC_WORKING_SET_PRIVILEGE,
+	"SeTimeZonePrivilege":             SE_TIME_ZONE_PRIVILEGE,
+	"SeCreateSymbolicLinkPrivilege":   SE_CREATE_SYMBOLIC_LINK_PRIVILEGE,
+}
+
+// LookupPrivilege returns the LUID of a privilege name such as "SeDebugPrivilege"
+func LookupPrivilege(name string) (uint32, error) {
+	for privilegeName, luid := range privilegeNames {
+		if strings.EqualFold(privilegeName, name) {
+			return luid, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown privilege %q", name)
+}
+
+// PrivilegeName returns the name of a well-known privilege LUID, or its number when unknown
+func PrivilegeName(privilege uint32) string {
+	for name, luid := range privilegeNames {
+		if luid == privilege {
+			return name
+		}
+	}
+	return fmt.Sprintf("Privilege(%d)", privilege)
+}
+
+// PrivilegeState reports whether tokenHandle holds privilege and whether it is enabled.
+// tokenHandle needs TOKEN_QUERY.
+func PrivilegeState(tokenHandle uintptr, privilege uint32) (held bool, enabled bool, err error) {
+	privileges, err := tokenPrivilegeList(tokenHandle)
+	if err != nil {
+		return false, false, err
+	}
+	for _, p := range privileges {
+		if p.Luid == privilege {
+			return true, p.Enabled, nil
+		}
+	}
+	return false, false, nil
+}
+
+// AdjustPrivilege enables or disables privilege on tokenHandle, which needs
+// TOKEN_ADJUST_PRIVILEGES. ErrPrivilegeNotHeld is returned when the token lacks the privilege.
+func AdjustPrivilege(tokenHandle uintptr, privilege uint32, enable bool) error {
+	newState := TOKEN_PRIVILEGES{PrivilegeCount: 1}
+	newState.Privileges[0].Luid = LUID{LowPart: privilege}
+	if enable {
+		newState.Privileges[0].Attributes = SE_PRIVILEGE_ENABLED
+	}
+
+	status, err := NtAdjustPrivilegesToken(tokenHandle, false, unsafe.Pointer(&newState), unsafe.Sizeof(newState), nil, nil)
+	if err != nil {
+		return fmt.Errorf("NtAdjustPrivilegesToken failed: %v", err)
+	}
+	switch status {
+	case STATUS_SUCCESS:
+	case STATUS_NOT_ALL_ASSIGNED:
+		return fmt.Errorf("privilege %d: %w", privilege, ErrPrivilegeNotHeld)
+	default:
+		return fmt.Errorf("NtAdjustPrivilegesToken failed: %s", FormatNTStatus(status))
+	}
+
+	debug.Printfln("TOKEN", "Privilege %d enabled=%v\n", privilege, enable)
+	return nil
+}
+
+// EnablePrivilege enables privilege (e.g. SE_DEBUG_PRIVILEGE) on the current process token
+func EnablePrivilege(privilege uint32) error {
+	return adjustCurrentProcessPrivilege(privilege, true)
+}
+
+// DisablePrivilege disables privilege on the current process token
+func DisablePrivilege(privilege uint32) error {
+	return adjustCurrentProcessPrivilege(privilege, false)
+}
+
+func adjustCurrentProcessPrivilege(privilege uint32, enable bool) error {
+	var token uintptr
+	status, err := NtOpenProcessToken(CURRENT_PROCESS, TOKEN_ADJUST_PRIVILEGES|TOKEN_QUERY, &token)
+	if err != nil || status != STATUS_SUCCESS {
+		return fmt.Errorf("NtOpenProcessToken failed: %v (%s)", err, FormatNTStatus(status))
+	}
+	defer NtClose(token)
+
+	return AdjustPrivilege(token, privilege, enable)
+}
+
+// TokenGroup is one group SID of a token
+type TokenGroup struct {
+	Sid        string
+	Attributes uint32 // SE_GROUP_* flags
+}
+
+// TokenPrivilege is one privilege of a token
+type TokenPrivilege struct {
+	Luid       uint32
+	Name       string
+	Attributes uint32 // SE_PRIVILEGE_* flags
+	Enabled    bool
+}
+
+// TokenInfo is a typed summary of a token, as returned by GetTokenInfo
+type TokenInfo struct {
+	UserSID string
+	// UserName is only resolved for well-known service accounts
+	UserName   string
+	Groups     []TokenGroup
+	Privileges []TokenPrivilege
+	Integrity  uint32
+	SessionId  uint32
+	Elevated   bool
+	// ElevationType is a TokenElevationType* value
+	ElevationType uint32
+	// Type is TokenPrimary or TokenImpersonation
+	Type uint32
+	// ImpersonationLevel is a Security* value; only set for impersonation tokens
+	ImpersonationLevel uint32
+}
+
+// GetTokenInfo queries the user, groups, privileges, integrity, session and elevation of
+// tokenHandle, which needs TOKEN_QUERY
+func GetTokenInfo(tokenHandle uintptr) (*TokenInfo, error) {
+	info := &TokenInfo{}
+
+	sid, err := tokenUserSID(tokenHandle)
+	if err != nil {
+		return nil, err
+	}
+	info.UserSID = sid
+	info.UserName = wellKnownAccounts[sid]
+
+	if info.Groups, err = tokenGroups(tokenHandle); err != nil {
+		return nil, err
+	}
+	if info.Privileges, err = tokenPrivilegeList(tokenHandle); err != nil {
+		return nil, err
+	}
+	if info.Type, err = tokenUint32(tokenHandle, TokenType); err != nil {
+		return nil, err
+	}
+	if info.Type == TokenImpersonation {
+		info.ImpersonationLevel, _ = tokenUint32(tokenHandle, TokenImpersonationLevel)
+	}
+
+	// Not every token carries these classes (anonymous and some impersonation tokens)
+	info.Integrity, _ = tokenIntegrityRID(tokenHandle)
+	info.SessionId, _ = tokenUint32(tokenHandle, TokenSessionId)
+	info.Elevated, _ = tokenElevated(tokenHandle)
+	info.ElevationType, _ = tokenUint32(tokenHandle, TokenElevationType)
+	return info, nil
+}
+
+// tokenUint32 queries an information class whose result is a single ULONG or enum
+func tokenUint32(tokenHandle uintptr, infoClass uintptr) (uint32, error) {
+	buffer, err := queryTokenInformation(tokenHandle, infoClass)
+	if err != nil {
+		return 0, err
+	}
+	if len(buffer) < 4 {
+		return 0, fmt.Errorf("NtQueryInformationToken(%d) returned %d bytes", infoClass, len(buffer))
+	}
+	return *(*uint32)(unsafe.Pointer(&buffer[0])), nil
+}
+
+// tokenGroups parses TOKEN_GROUPS for tokenHandle
+func tokenGroups(tokenHandle uintptr) ([]TokenGroup, error) {
+	buffer, err := queryTokenInformation(tokenHandle, TokenGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	count := *(*uint32)(unsafe.Pointer(&buffer[0]))
+	header := unsafe.Sizeof(uintptr(0)) // GroupCount padded to pointer alignment
+	entrySize := unsafe.Sizeof(SID_AND_ATTRIBUTES{})
+	groups := make([]TokenGroup, 0, count)
+	for i := uintptr(0); i < uintptr(count); i++ {
+		offset := header + i*entrySize
+		if offset+entrySize > uintptr(len(buffer)) {
+			break
+		}
+		entry := (*SID_AND_ATTRIBUTES)(unsafe.Pointer(&buffer[offset]))
+		if entry.Sid == 0 {
+			continue
+		}
+		groups = append(groups, TokenGroup{Sid: sidToString(entry.Sid), Attributes: entry.Attributes})
+	}
+	return groups, nil
+}
+
+// tokenPrivilegeList parses TOKEN_PRIVILEGES for tokenHandle
+func tokenPrivilegeList(tokenHandle uintptr) ([]TokenPrivilege, error) {
+	buffer, err := queryTokenInformation(tokenHandle, TokenPrivileges)
+	if err != nil {
+		return nil, err
+	}
+
+	count := *(*uint32)(unsafe.Pointer(&buffer[0]))
+	entrySize := unsafe.Sizeof(LUID_AND_ATTRIBUTES{})
+	first := unsafe.Offsetof(TOKEN_PRIVILEGES{}.Privileges)
+	privileges := make([]TokenPrivilege, 0, count)
+	for i := uintptr(0); i < uintptr(count); i++ {
+		offset := first + i*entrySize
+		if offset+entrySize > uintptr(len(buffer)) {
+			break
+		}
+		entry := (*LUID_AND_ATTRIBUTES)(unsafe.Pointer(&buffer[offset]))
+		privileges = append(privileges, TokenPrivilege{
+			Luid:       entry.Luid.LowPart,
+			Name:       PrivilegeName(entry.Luid.LowPart),
+			Attributes: entry.Attributes,
+			Enabled:    entry.Attributes&SE_PRIVILEGE_ENABLED != 0,
+		})
+	}
+	return privileges, nil
+}
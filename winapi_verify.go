@@ -0,0 +1,225 @@
+// Package winapi - Injection Verification Module
+// Provides signals that let Inject confirm the payload actually ran in the target
+package winapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/carved4/go-native-syscall/pkg/debug"
+)
+
+// VerifyMethod selects how Inject confirms that the payload ran
+type VerifyMethod int
+
+const (
+	// VerifyNone reports success as soon as the execute step succeeds
+	VerifyNone VerifyMethod = iota
+	// VerifyThreadExit waits for the payload thread to exit and reports its exit status.
+	// Only valid with ExecCreateThread.
+	VerifyThreadExit
+	// VerifyEvent creates a named notification event that the payload sets once it runs
+	// (OpenEventW(EVENT_MODIFY_STATE, FALSE, L"Local\\<name>") + SetEvent for a target in the
+	// caller's session)
+	VerifyEvent
+	// VerifySharedStatus maps a shared page into the target and passes its address as the payload
+	// argument (RCX for threads and HijackRestore, the first argument for APCs). The payload
+	// writes a non-zero uint32 status to it once it runs. HijackReturn passes no argument, so it
+	// cannot be combined with this method.
+	VerifySharedStatus
+)
+
+// VerifyOptions configures the verification step of Inject
+type VerifyOptions struct {
+	Method VerifyMethod
+	// EventName is the event to wait on for VerifyEvent; empty generates a unique name, which is
+	// returned in VerifyResult.EventName. Names without a leading backslash are placed in the
+	// caller's session namespace.
+	EventName string
+	// Timeout bounds the wait for the signal; 0 means 10 seconds
+	Timeout time.Duration
+}
+
+// VerifyResult reports what the verification step observed
+type VerifyResult struct {
+	Method VerifyMethod
+	// Executed is true once the signal was observed: the thread exited, the event was set or a
+	// status was written
+	Executed bool
+	// Status is the thread exit status (VerifyThreadExit) or the value written by the payload
+	// (VerifySharedStatus)
+	Status    uint32
+	EventName string
+	// StatusAddress is the address of the shared status page in the target (VerifySharedStatus)
+	StatusAddress uintptr
+}
+
+const (
+	verifyDefaultTimeout = 10 * time.Second
+	verifyPollInterval   = 20 * time.Millisecond
+	verifyStatusPageSize = 0x1000
+)
+
+// verifier holds the objects prepared before execution for one verification method
+type verifier struct {
+	opts          VerifyOptions
+	processHandle uintptr
+	event         uintptr
+	eventName     string
+	section       uintptr
+	localView     uintptr
+	remoteView    uintptr
+}
+
+// prepareVerifier creates the event or shared page the payload will signal through. It must
+// run before the execute step so the signal cannot be missed.
+func prepareVerifier(processHandle uintptr, pid uint32, opts InjectOptions) (*verifier, error) {
+	v := &verifier{opts: opts.Verify, processHandle: processHandle}
+
+	switch opts.Verify.Method {
+	case VerifyNone:
+		return v, nil
+
+	case VerifyThreadExit:
+		if opts.Execution != ExecCreateThread {
+			return nil, stepError("verify", 0, fmt.Errorf("VerifyThreadExit requires ExecCreateThread"))
+		}
+		return v, nil
+
+	case VerifyEvent:
+		v.eventName = opts.Verify.EventName
+		if v.eventName == "" {
+			v.eventName = fmt.Sprintf("inject-verify-%d-%d", pid, time.Now().UnixNano())
+		}
+		objectName := channelObjectName(v.eventName)
+		eventName := NewUnicodeString(StringToUTF16(objectName))
+		objAttr := OBJECT_ATTRIBUTES{
+			Length:     uint32(unsafe.Sizeof(OBJECT_ATTRIBUTES{})),
+			ObjectName: &eventName,
+			Attributes: OBJ_CASE_INSENSITIVE,
+		}
+		status, err := NtCreateEvent(&v.event, EVENT_ALL_ACCESS, uintptr(unsafe.Pointer(&objAttr)), NotificationEvent, false)
+		if err != nil || status != STATUS_SUCCESS {
+			return nil, stepError("verify", status, fmt.Errorf("NtCreateEvent failed for %s: %v", objectName, err))
+		}
+		debug.Printfln("VERIFY", "Waiting for payload to set event %s\n", objectName)
+		return v, nil
+
+	case VerifySharedStatus:
+		if opts.Execution == ExecHijackThread && opts.Hijack.Mode == HijackReturn {
+			return nil, stepError("verify", 0, fmt.Errorf("VerifySharedStatus requires HijackRestore to pass the status address"))
+		}
+		maxSize := uint64(verifyStatusPageSize)
+		status, err := NtCreateSection(&v.section, SECTION_ALL_ACCESS, 0, &maxSize, PAGE_READWRITE, SEC_COMMIT, 0)
+		if err != nil || status != STATUS_SUCCESS {
+			return nil, stepError("verify", status, err)
+		}
+
+		var offset uint64
+		viewSize := uintptr(0)
+		status, err = NtMapViewOfSection(v.section, CURRENT_PROCESS, &v.localView, 0, 0, &offset, &viewSize, ViewUnmap, 0, PAGE_READWRITE)
+		if err != nil || status != STATUS_SUCCESS {
+			v.close(false)
+			return nil, stepError("verify", status, err)
+		}
+
+		offset = 0
+		viewSize = 0
+		status, err = NtMapViewOfSection(v.section, processHandle, &v.remoteView, 0, 0, &offset, &viewSize, ViewUnmap, 0, PAGE_READWRITE)
+		if err != nil || status != STATUS_SUCCESS {
+			v.close(false)
+			return nil, stepError("verify", status, err)
+		}
+		debug.Printfln("VERIFY", "Shared status page at 0x%X in target\n", v.remoteView)
+		return v, nil
+	}
+
+	return nil, stepError("verify", 0, fmt.Errorf("unknown verification method %d", opts.Verify.Method))
+}
+
+// argument returns the value to pass to the payload, or fallback when the method passes nothing
+func (v *verifier) argument(fallback uintptr) uintptr {
+	if v.opts.Method == VerifySharedStatus {
+		return v.remoteView
+	}
+	return fallback
+}
+
+// wait blocks until the signal is observed or the timeout expires. threadHandle is only used
+// by VerifyThreadExit.
+func (v *verifier) wait(threadHandle uintptr) (*VerifyResult, error) {
+	result := &VerifyResult{Method: v.opts.Method, EventName: v.eventName, StatusAddress: v.remoteView}
+	if v.opts.Method == VerifyNone {
+		return result, nil
+	}
+
+	timeout := v.opts.Timeout
+	if timeout <= 0 {
+		timeout = verifyDefaultTimeout
+	}
+
+	switch v.opts.Method {
+	case VerifyThreadExit:
+		status, err := NtWaitForSingleObject(threadHandle, false, relativeTimeout(timeout))
+		if err != nil {
+			return result, stepError("verify", 0, err)
+		}
+		if status != WAIT_OBJECT_0 {
+			return result, nil
+		}
+		exitStatus, err := threadExitStatus(threadHandle)
+		if err != nil {
+			return result, stepError("verify", 0, err)
+		}
+		result.Executed = true
+		result.Status = exitStatus
+
+	case VerifyEvent:
+		status, err := NtWaitForSingleObject(v.event, false, relativeTimeout(timeout))
+		if err != nil {
+			return result, stepError("verify", 0, err)
+		}
+		result.Executed = status == WAIT_OBJECT_0
+
+	case VerifySharedStatus:
+		slot := unsafe.Slice((*byte)(unsafe.Pointer(v.localView)), 4)
+		deadline := time.Now().Add(timeout)
+		for {
+			if value := binary.LittleEndian.Uint32(slot); value != 0 {
+				result.Executed = true
+				result.Status = value
+				break
+			}
+			if !time.Now().Before(deadline) {
+				break
+			}
+			time.Sleep(verifyPollInterval)
+		}
+	}
+
+	debug.Printfln("VERIFY", "Verification done: executed=%v status=0x%X\n", result.Executed, result.Status)
+	return result, nil
+}
+
+// close releases the verification objects. The remote status page is only unmapped when the
+// payload is known to be done with it.
+func (v *verifier) close(unmapRemote bool) {
+	if v.event != 0 {
+		NtClose(v.event)
+		v.event = 0
+	}
+	if v.localView != 0 {
+		NtUnmapViewOfSection(CURRENT_PROCESS, v.localView)
+		v.localView = 0
+	}
+	if v.remoteView != 0 && unmapRemote {
+		NtUnmapViewOfSection(v.processHandle, v.remoteView)
+		v.remoteView = 0
+	}
+	if v.section != 0 {
+		NtClose(v.section)
+		v.section = 0
+	}
+}
@@ -0,0 +1,57 @@
+// Package winapi - Secure Wipe Module
+// Provides RtlSecureZeroMemory-equivalent helpers for buffers holding keys, payloads and secrets
+package winapi
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// WipeMemory zeroes size bytes at address. Every store goes through a volatile-style pointer
+// write followed by a KeepAlive, so the compiler cannot drop the wipe as a dead store the way it
+// may for a plain clear before free. The memory must be committed and writable.
+func WipeMemory(address uintptr, size uintptr) {
+	if address == 0 || size == 0 {
+		return
+	}
+
+	p := unsafe.Pointer(address)
+	for i := uintptr(0); i < size; i++ {
+		*(*byte)(unsafe.Add(p, i)) = 0
+	}
+	runtime.KeepAlive(p)
+}
+
+// WipeBytes zeroes the full capacity of b, not just its length, so data left past len(b) by
+// earlier appends or reslicing is cleared as well
+func WipeBytes(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	b = b[:cap(b)]
+	WipeMemory(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	runtime.KeepAlive(b)
+}
+
+// WipeString zeroes the bytes backing *s and sets *s to "". Strings built at runtime live in
+// writable heap memory and are wiped in place; string literals live in the read-only data
+// section and cannot be, in which case only the reference is cleared and false is returned.
+func WipeString(s *string) bool {
+	if s == nil || len(*s) == 0 {
+		return true
+	}
+
+	data := unsafe.StringData(*s)
+	size := uintptr(len(*s))
+	wiped := false
+
+	mbi, err := queryRegion(CURRENT_PROCESS, uintptr(unsafe.Pointer(data)))
+	if err == nil && mbi.State == MEM_COMMIT && mbi.Protect&(PAGE_READWRITE|PAGE_EXECUTE_READWRITE) != 0 &&
+		uintptr(unsafe.Pointer(data))+size <= mbi.BaseAddress+mbi.RegionSize {
+		WipeMemory(uintptr(unsafe.Pointer(data)), size)
+		wiped = true
+	}
+
+	*s = ""
+	return wiped
+}